@@ -5,11 +5,17 @@ import (
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/raojinlin/mysql-user-migrate/internal/cli"
 	"github.com/raojinlin/mysql-user-migrate/internal/config"
+	"github.com/raojinlin/mysql-user-migrate/internal/credential"
+	"github.com/raojinlin/mysql-user-migrate/internal/crypt"
 	"github.com/raojinlin/mysql-user-migrate/internal/migrate"
 )
 
@@ -27,29 +33,102 @@ func main() {
 	merged := config.Merge(fileCfg, opts.Config)
 	applyEnvDefaults(&merged)
 
+	logger := log.New(io.Discard, "", log.LstdFlags)
+	if merged.Verbose {
+		logger = log.New(os.Stdout, "[mysql-user-migrate] ", log.LstdFlags)
+	}
+
+	key, err := crypt.LoadKey(merged.Encryption.PassphraseEnv, merged.Encryption.KeyFile)
+	if err != nil {
+		log.Fatalf("encryption: %v", err)
+	}
+
+	switch opts.Command {
+	case cli.CommandExport:
+		runExport(merged, key, opts, logger)
+	case cli.CommandEncrypt, cli.CommandDecrypt:
+		runCrypt(opts, key)
+	case cli.CommandValidate:
+		runValidate(merged, key, logger)
+	case cli.CommandDump:
+		runDump(merged, key, opts, logger)
+	case cli.CommandAccount:
+		runAccount(merged, key, opts, logger)
+	case cli.CommandPrecheck:
+		// A precheck is a dry run that blocks targets missing privileges.
+		merged.DryRun, merged.Precheck, merged.SQLDir = true, true, ""
+		merged.Journal, merged.Resume = "", ""
+		runMigrate(merged, key, logger)
+	default:
+		runMigrate(merged, key, logger)
+	}
+}
+
+func runMigrate(merged config.RuntimeConfig, key *crypt.Key, logger *log.Logger) {
 	if err := merged.Validate(); err != nil {
 		log.Fatalf("config: %v", err)
 	}
 
-	logger := log.New(io.Discard, "", log.LstdFlags)
-	if merged.Verbose {
-		logger = log.New(os.Stdout, "[mysql-user-migrate] ", log.LstdFlags)
+	var (
+		sink credential.Sink
+		err  error
+	)
+	if merged.Credentials.Sink.Type != "" && !merged.DryRun {
+		sink, err = credential.NewSink(merged.Credentials.Sink)
+		if err != nil {
+			log.Fatalf("credential sink: %v", err)
+		}
 	}
 
-	runner := migrate.Runner{
-		SourceDSN:      merged.Source,
-		Targets:        merged.Targets,
-		Include:        merged.Include,
-		Exclude:        merged.Exclude,
-		DryRun:         merged.DryRun,
-		DropMissing:    merged.DropMissing,
-		ForceOverwrite: merged.ForceOverwrite,
-		Concurrency:    merged.Concurrency,
-		Logger:         logger,
+	var secrets *credential.Secrets
+	if src := merged.Credentials.Secrets; src.File != "" || src.Command != "" {
+		secrets, err = credential.LoadSecrets(src)
+		if err != nil {
+			log.Fatalf("credential secrets: %v", err)
+		}
 	}
 
-	ctx := context.Background()
+	runner := newRunner(merged, key, logger)
+	runner.Sink = sink
+	runner.Secrets = secrets
+	if merged.Journal != "" || merged.Resume != "" {
+		path := merged.Journal
+		if path == "" {
+			path = merged.Resume // resuming continues the same journal
+		}
+		runner.Journal, err = migrate.OpenJournal(path, merged.Resume)
+		if err != nil {
+			log.Fatalf("journal: %v", err)
+		}
+		defer runner.Journal.Close()
+	}
+
+	// The first SIGINT or SIGTERM during the run lets accounts in flight finish and reports
+	// the rest as cancelled; a second one kills the process. Once the run returns, signals
+	// have their default effect again.
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			log.Printf("%v: finishing accounts in flight; signal again to abort", sig)
+			cancel()
+		case <-done:
+		}
+	}()
+
 	report, err := runner.Run(ctx)
+	signal.Stop(signals)
+	close(done)
+	cancel()
+	if sink != nil {
+		if cerr := sink.Close(); cerr != nil {
+			log.Printf("credential sink: %v", cerr)
+		}
+	}
 	if err != nil {
 		log.Fatalf("migrate: %v", err)
 	}
@@ -60,10 +139,251 @@ func main() {
 			log.Printf("write report: %v", err)
 		}
 	}
+	if report.Cancelled {
+		if err := runner.Journal.Close(); err != nil {
+			log.Printf("journal: %v", err)
+		}
+		log.Fatalf("migrate: cancelled")
+	}
+}
+
+func runExport(merged config.RuntimeConfig, key *crypt.Key, opts cli.Options, logger *log.Logger) {
+	if err := merged.ValidateSource(); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if opts.OutputPath == "" {
+		log.Fatalf("export: --out is required")
+	}
+	hashes := migrate.HashMode(opts.Hashes)
+	switch hashes {
+	case "", migrate.HashesInline, migrate.HashesVariables, migrate.HashesOmit:
+	default:
+		log.Fatalf("export: unknown --hashes %q (inline, variables or omit)", opts.Hashes)
+	}
+
+	runner := newRunner(merged, key, logger)
+	snap, err := runner.Export(context.Background())
+	if err != nil {
+		log.Fatalf("export: %v", err)
+	}
+
+	switch opts.Format {
+	case "", "snapshot":
+		if hashes == migrate.HashesVariables {
+			log.Fatalf("export: --hashes variables is not supported for snapshots")
+		}
+		if hashes == migrate.HashesOmit {
+			snap.RedactHashes()
+		}
+		if err := snap.WriteFile(opts.OutputPath, key); err != nil {
+			log.Fatalf("export: %v", err)
+		}
+	case "terraform", "ansible":
+		if hashes == "" {
+			hashes = migrate.HashesVariables
+		}
+		writeConfigExport(snap, opts.Format, hashes, opts.OutputPath, key)
+	default:
+		log.Fatalf("export: unknown --format %q", opts.Format)
+	}
+	log.Printf("exported %d accounts to %s (encrypted=%v)", len(snap.Accounts), opts.OutputPath, key != nil)
+}
+
+// writeConfigExport writes Terraform or Ansible configuration to path and any hash
+// variables to a file beside it. Files holding hashes are sealed when a key is configured.
+func writeConfigExport(snap *migrate.Snapshot, format string, hashes migrate.HashMode, path string, key *crypt.Key) {
+	var (
+		data, vars []byte
+		warnings   []string
+		varsPath   string
+		err        error
+	)
+	if format == "terraform" {
+		data, vars, warnings, err = snap.Terraform(hashes)
+		varsPath = strings.TrimSuffix(path, ".tf") + ".auto.tfvars"
+	} else {
+		data, vars, warnings, err = snap.Ansible(hashes)
+		varsPath = strings.TrimSuffix(path, filepath.Ext(path)) + "-hashes.yml"
+	}
+	if err != nil {
+		log.Fatalf("export: %v", err)
+	}
+	for _, w := range warnings {
+		log.Printf("export: %s", w)
+	}
+	dataKey := key
+	if hashes != migrate.HashesInline {
+		dataKey = nil
+	}
+	if err := crypt.WriteFile(path, data, dataKey); err != nil {
+		log.Fatalf("export: %v", err)
+	}
+	if vars != nil {
+		if err := crypt.WriteFile(varsPath, vars, key); err != nil {
+			log.Fatalf("export: %v", err)
+		}
+		log.Printf("wrote password hash variables to %s", varsPath)
+	}
+}
+
+// runValidate loads the source without touching targets, e.g. to check desired-state files
+// in CI.
+func runValidate(merged config.RuntimeConfig, key *crypt.Key, logger *log.Logger) {
+	if err := merged.ValidateSource(); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	snap, err := newRunner(merged, key, logger).Export(context.Background())
+	if err != nil {
+		log.Fatalf("validate: %v", err)
+	}
+	log.Printf("%s: %d accounts valid", snap.Source, len(snap.Accounts))
+}
+
+// runDump writes a canonical account dump for version control: to stdout, to a single file,
+// or with --split to one file per account.
+func runDump(merged config.RuntimeConfig, key *crypt.Key, opts cli.Options, logger *log.Logger) {
+	if err := merged.ValidateSource(); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	redact := false
+	switch migrate.HashMode(opts.Hashes) {
+	case "", migrate.HashesInline:
+	case migrate.HashesOmit:
+		redact = true
+	default:
+		log.Fatalf("dump: unknown --hashes %q (inline or omit)", opts.Hashes)
+	}
+	if opts.Split && opts.OutputPath == "" {
+		log.Fatalf("dump: --split requires --out")
+	}
+
+	snap, err := newRunner(merged, key, logger).Export(context.Background())
+	if err != nil {
+		log.Fatalf("dump: %v", err)
+	}
+	accounts, err := snap.Dump(redact)
+	if err != nil {
+		log.Fatalf("dump: %v", err)
+	}
+	// Redacted dumps hold nothing secret and stay readable for diffs.
+	if redact {
+		key = nil
+	}
+
+	switch {
+	case opts.OutputPath == "":
+		if _, err := os.Stdout.Write(snap.RenderDump(accounts)); err != nil {
+			log.Fatalf("dump: %v", err)
+		}
+		return
+	case opts.Split:
+		err = snap.WriteDumpDir(opts.OutputPath, accounts, key)
+	default:
+		err = crypt.WriteFile(opts.OutputPath, snap.RenderDump(accounts), key)
+	}
+	if err != nil {
+		log.Fatalf("dump: %v", err)
+	}
+	log.Printf("dumped %d accounts to %s (encrypted=%v)", len(accounts), opts.OutputPath, key != nil)
+}
+
+// runAccount prints the statements that create a least-privilege migration account on the
+// source and every target.
+func runAccount(merged config.RuntimeConfig, key *crypt.Key, opts cli.Options, logger *log.Logger) {
+	if err := merged.Validate(); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	account := migrate.Identity{User: opts.Account, Host: "%"}
+	if at := strings.LastIndex(opts.Account, "@"); at >= 0 {
+		account = migrate.Identity{User: opts.Account[:at], Host: opts.Account[at+1:]}
+	}
+	if account.User == "" {
+		log.Fatalf("account: --account needs a user name")
+	}
+
+	sql, err := newRunner(merged, key, logger).MigrationAccount(context.Background(), account)
+	if err != nil {
+		log.Fatalf("account: %v", err)
+	}
+	if opts.OutputPath == "" {
+		if _, err := os.Stdout.Write(sql); err != nil {
+			log.Fatalf("account: %v", err)
+		}
+		return
+	}
+	if err := crypt.WriteFile(opts.OutputPath, sql, nil); err != nil {
+		log.Fatalf("account: %v", err)
+	}
+	log.Printf("wrote migration account statements to %s", opts.OutputPath)
+}
+
+// runCrypt seals or opens a file, e.g. a snapshot, plan or secrets mapping.
+func runCrypt(opts cli.Options, key *crypt.Key) {
+	if opts.InputPath == "" || opts.OutputPath == "" {
+		log.Fatalf("%s: --in and --out are required", opts.Command)
+	}
+	if key == nil {
+		log.Fatalf("%s: --passphrase-env or --key-file is required", opts.Command)
+	}
+	// Encrypt takes plaintext, and re-seals sealed input with key; decrypt takes sealed input only.
+	data, err := os.ReadFile(opts.InputPath)
+	if err == nil && (opts.Command == cli.CommandDecrypt || crypt.IsSealed(data)) {
+		data, err = crypt.Open(data, key)
+	}
+	if err != nil {
+		log.Fatalf("%s: read %s: %v", opts.Command, opts.InputPath, err)
+	}
+	if opts.Command == cli.CommandDecrypt {
+		key = nil
+	}
+	if err := crypt.WriteFile(opts.OutputPath, data, key); err != nil {
+		log.Fatalf("%s: write %s: %v", opts.Command, opts.OutputPath, err)
+	}
+}
+
+func newRunner(merged config.RuntimeConfig, key *crypt.Key, logger *log.Logger) *migrate.Runner {
+	return &migrate.Runner{
+		Source:         newSource(merged, key),
+		SourceDSN:      merged.Source,
+		Targets:        merged.Targets,
+		Include:        merged.Include,
+		Exclude:        merged.Exclude,
+		UserMap:        merged.UserMap,
+		PrivilegeRules: merged.PrivilegeRules,
+		Credentials:    merged.Credentials,
+		ScriptDir:      merged.SQLDir,
+		Key:            key,
+		MissingObjects: merged.MissingObjects,
+		OnPartial:      merged.OnPartial,
+		BackupDir:      merged.BackupDir,
+		RollbackOnFail: merged.RollbackOnFail,
+		Retry:          merged.Retry,
+		Timeouts:       merged.Timeouts,
+		DryRun:         merged.DryRun,
+		Precheck:       merged.Precheck,
+		DropMissing:    merged.DropMissing,
+		ForceOverwrite: merged.ForceOverwrite,
+		Concurrency:    merged.Concurrency,
+		Logger:         logger,
+	}
+}
+
+func newSource(merged config.RuntimeConfig, key *crypt.Key) migrate.Source {
+	switch {
+	case merged.SourceSnapshot != "":
+		return &migrate.SnapshotSource{Path: merged.SourceSnapshot, Key: key}
+	case len(merged.SourceScripts) > 0:
+		return &migrate.ScriptSource{Paths: merged.SourceScripts, Key: key}
+	case len(merged.SourceDump) > 0:
+		return &migrate.DumpSource{Paths: merged.SourceDump, Key: key}
+	case merged.SourceDir != "":
+		return &migrate.DirectorySource{Path: merged.SourceDir}
+	}
+	return &migrate.MySQLSource{DSN: merged.Source, ConnectTimeout: time.Duration(merged.Timeouts.Connect)}
 }
 
 func applyEnvDefaults(cfg *config.RuntimeConfig) {
-	if cfg.Source == "" {
+	if !cfg.HasSource() {
 		if v := os.Getenv("SOURCE_DSN"); v != "" {
 			cfg.Source = v
 		}
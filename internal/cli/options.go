@@ -5,65 +5,190 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/raojinlin/mysql-user-migrate/internal/config"
 )
 
+// Commands supported as the first argument; migrate is the default.
+const (
+	CommandMigrate  = "migrate"
+	CommandExport   = "export"
+	CommandEncrypt  = "encrypt"
+	CommandDecrypt  = "decrypt"
+	CommandValidate = "validate"
+	CommandDump     = "dump"
+	CommandPrecheck = "precheck"
+	CommandAccount  = "account"
+)
+
+var commands = map[string]bool{
+	CommandMigrate:  true,
+	CommandExport:   true,
+	CommandEncrypt:  true,
+	CommandDecrypt:  true,
+	CommandValidate: true,
+	CommandDump:     true,
+	CommandPrecheck: true,
+	CommandAccount:  true,
+}
+
 // Options parses and holds CLI-provided configuration.
 type Options struct {
+	Command    string
 	ConfigPath string
+	InputPath  string
+	OutputPath string
+	Format     string // export format
+	Hashes     string // export hash handling
+	Split      bool   // dump one file per account
+	Account    string // user@host of the generated migration account
 	Config     config.CLIConfig
 }
 
-// ParseOptions parses command-line flags into Options.
+// ParseOptions parses an optional command followed by command-line flags into Options.
 func ParseOptions(args []string) (Options, error) {
+	command := CommandMigrate
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if !commands[args[0]] {
+			return Options{}, fmt.Errorf("unknown command %q", args[0])
+		}
+		command, args = args[0], args[1:]
+	}
+
 	var (
+		inputPath  string
+		outputPath string
+		format     string
+		hashes     string
+		split      bool
+		account    string
+		passEnv    string
+		keyFile    string
 		configPath string
 		sourceDSN  string
+		snapshot   string
+		scripts    stringListFlag
+		dumps      stringListFlag
+		stateDir   string
 		targets    stringListFlag
 		include    stringListFlag
 		exclude    stringListFlag
+		userMap    stringListFlag
 		reportPath string
+		sqlDir     string
+		missingObj string
+		onPartial  string
+		backupDir  string
+		journal    string
+		resume     string
+
+		connectTimeout   time.Duration
+		statementTimeout time.Duration
+		targetTimeout    time.Duration
+		runTimeout       time.Duration
 
 		dryRunFlag         boolFlag
+		precheckFlag       boolFlag
+		rollbackFlag       boolFlag
 		dropMissingFlag    boolFlag
 		forceOverwriteFlag boolFlag
 		verboseFlag        boolFlag
 		concurrencyFlag    intFlag
+		retriesFlag        intFlag
 	)
 
-	fs := flag.NewFlagSet("mysql-user-migrate", flag.ContinueOnError)
+	fs := flag.NewFlagSet("mysql-user-migrate "+command, flag.ContinueOnError)
 	fs.StringVar(&configPath, "config", "", "Path to YAML/JSON config file")
+	fs.StringVar(&inputPath, "in", "", "Input path for encrypt/decrypt")
+	fs.StringVar(&outputPath, "out", "", "Output path for export (.json or .yaml/.yml), encrypt and decrypt")
+	fs.StringVar(&format, "format", "snapshot", "Export format: snapshot, terraform or ansible")
+	fs.StringVar(&hashes, "hashes", "", "Export password hashes inline, as variables (terraform/ansible default) or omit them")
+	fs.BoolVar(&split, "split", false, "Dump one file per account into the --out directory")
+	fs.StringVar(&account, "account", "mysql_migrate@%", "Migration account (user@host) for the account command")
+	fs.StringVar(&passEnv, "passphrase-env", "", "Environment variable holding the passphrase for encrypted snapshots and plan files")
+	fs.StringVar(&keyFile, "key-file", "", "File holding a 32-byte key for encrypted snapshots and plan files")
 	fs.StringVar(&sourceDSN, "source", "", "Source MySQL DSN (e.g., user:pass@tcp(host:3306)/)")
+	fs.StringVar(&snapshot, "from-snapshot", "", "Read source accounts from a snapshot file instead of a live source")
+	fs.Var(&scripts, "from-sql", "Read source accounts from mysqlpump or MySQL Shell user scripts; repeatable")
+	fs.Var(&dumps, "from-dump", "Read source accounts from a mysqldump of the mysql schema; repeatable")
+	fs.StringVar(&stateDir, "from-dir", "", "Read desired-state account files (*.yaml) from this directory")
 	fs.Var(&targets, "target", "Target MySQL DSN; repeatable (name=dsn supported)")
 	fs.Var(&include, "include", "Comma-separated list of users or user@host to include")
 	fs.Var(&exclude, "exclude", "Comma-separated list of users or user@host to exclude")
+	fs.Var(&userMap, "user-map", "Rename users on targets as from=to; repeatable (wildcards supported, e.g. legacy_*=app_*)")
 	fs.StringVar(&reportPath, "report", "", "Path to write report (JSON)")
+	fs.StringVar(&sqlDir, "sql-out", "", "Write one .sql script per target to this directory instead of executing")
+	fs.StringVar(&missingObj, "missing-objects", "", "Grants on missing objects: fail, skip-grant or create-empty-schema")
+	fs.StringVar(&onPartial, "on-partial", "", "Accounts where some grants failed: keep or rollback")
+	fs.StringVar(&backupDir, "backup-dir", "", "Write one undo script per target to this directory before changing it")
+	fs.Var(&rollbackFlag, "rollback-on-failure", "Run the undo script when a target has failed accounts")
+	fs.StringVar(&journal, "journal", "", "Record each account's outcome per target in this file")
+	fs.StringVar(&resume, "resume", "", "Continue from a journal: skip accounts it records as applied and retry the rest")
+	fs.DurationVar(&connectTimeout, "connect-timeout", 0, "Timeout for each connection attempt (default 5s)")
+	fs.DurationVar(&statementTimeout, "statement-timeout", 0, "Timeout for each statement applied to an account")
+	fs.DurationVar(&targetTimeout, "target-timeout", 0, "Timeout for all work on one target; other targets continue")
+	fs.DurationVar(&runTimeout, "run-timeout", 0, "Deadline for the whole run; accounts not started by then are not applied")
 	fs.Var(&dryRunFlag, "dry-run", "Plan only; do not apply changes")
+	fs.Var(&precheckFlag, "precheck", "Check the target account's own privileges first; block targets that would fail")
 	fs.Var(&dropMissingFlag, "drop-missing", "Drop/replace target users to match source (cleans extra grants)")
 	fs.Var(&forceOverwriteFlag, "force-overwrite", "Force reset of existing users (drop and recreate)")
 	fs.Var(&verboseFlag, "verbose", "Verbose logs")
+	fs.Var(&retriesFlag, "retry-attempts", "Tries per statement or connection on transient MySQL errors (1 disables retries)")
 	fs.Var(&concurrencyFlag, "concurrency", "Number of targets to migrate concurrently")
 
 	if err := fs.Parse(args); err != nil {
 		return Options{}, err
 	}
 
+	mappings, err := parseUserMap(userMap.values)
+	if err != nil {
+		return Options{}, err
+	}
+
 	cfg := config.CLIConfig{
 		Source:         sourceDSN,
+		SourceSnapshot: snapshot,
+		SourceScripts:  scripts.values,
+		SourceDump:     dumps.values,
+		SourceDir:      stateDir,
 		Targets:        parseTargets(targets.values),
 		Include:        include.values,
 		Exclude:        exclude.values,
+		UserMap:        mappings,
+		Encryption:     config.Encryption{PassphraseEnv: passEnv, KeyFile: keyFile},
 		ReportPath:     reportPath,
+		SQLDir:         sqlDir,
+		MissingObjects: missingObj,
+		OnPartial:      onPartial,
+		BackupDir:      backupDir,
+		RollbackOnFail: boolPtr(rollbackFlag),
+		Journal:        journal,
+		Resume:         resume,
 		DryRun:         boolPtr(dryRunFlag),
+		Precheck:       boolPtr(precheckFlag),
 		DropMissing:    boolPtr(dropMissingFlag),
 		ForceOverwrite: boolPtr(forceOverwriteFlag),
 		Verbose:        boolPtr(verboseFlag),
 		Concurrency:    intPtr(concurrencyFlag),
+		RetryAttempts:  intPtr(retriesFlag),
+		Timeouts: config.Timeouts{
+			Connect:   config.Duration(connectTimeout),
+			Statement: config.Duration(statementTimeout),
+			Target:    config.Duration(targetTimeout),
+			Run:       config.Duration(runTimeout),
+		},
 	}
 
 	return Options{
+		Command:    command,
 		ConfigPath: configPath,
+		InputPath:  inputPath,
+		OutputPath: outputPath,
+		Format:     format,
+		Hashes:     hashes,
+		Split:      split,
+		Account:    account,
 		Config:     cfg,
 	}, nil
 }
@@ -156,3 +281,19 @@ func parseTargets(values []string) []config.Target {
 	}
 	return targets
 }
+
+// parseUserMap builds user mappings from "from=to" values.
+func parseUserMap(values []string) ([]config.UserMapping, error) {
+	mappings := make([]config.UserMapping, 0, len(values))
+	for _, raw := range values {
+		from, to, ok := strings.Cut(raw, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid user-map %q: expected from=to", raw)
+		}
+		mappings = append(mappings, config.UserMapping{
+			From: strings.TrimSpace(from),
+			To:   strings.TrimSpace(to),
+		})
+	}
+	return mappings, nil
+}
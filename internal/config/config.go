@@ -6,40 +6,241 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Target describes a destination MySQL instance.
 type Target struct {
-	Name string `json:"name" yaml:"name"`
-	DSN  string `json:"dsn" yaml:"dsn"`
+	Name           string        `json:"name" yaml:"name"`
+	DSN            string        `json:"dsn" yaml:"dsn"`
+	Group          string        `json:"group" yaml:"group"`
+	AuthMode       string        `json:"auth_mode" yaml:"auth_mode"`             // overrides credentials.mode
+	AuthPlugin     string        `json:"auth_plugin" yaml:"auth_plugin"`         // plugin for passwords set in cleartext
+	MissingObjects string        `json:"missing_objects" yaml:"missing_objects"` // overrides missing_objects
+	Timeouts       Timeouts      `json:"timeouts" yaml:"timeouts"`               // overrides timeouts, except run
+	HostRewrite    []HostRewrite `json:"host_rewrite" yaml:"host_rewrite"`
+}
+
+// HostRewrite maps source account hosts to target hosts. Match is an exact host, a pattern using
+// % or * wildcards, or a CIDR block; Replace may reference the wildcards of a pattern match.
+type HostRewrite struct {
+	Match   string `json:"match" yaml:"match"`
+	Replace string `json:"replace" yaml:"replace"`
+}
+
+// UserMapping renames source users on targets. From is an exact user name or a pattern using
+// % or * wildcards; To may reference the wildcards of a pattern match (e.g. legacy_* -> app_*).
+type UserMapping struct {
+	From string `json:"from" yaml:"from"`
+	To   string `json:"to" yaml:"to"`
+}
+
+// PrivilegeRule transforms parsed grants before they are issued on matching targets.
+type PrivilegeRule struct {
+	Name              string            `json:"name" yaml:"name"`
+	Targets           []string          `json:"targets" yaml:"targets"` // target names or groups; empty matches all
+	Map               map[string]string `json:"map" yaml:"map"`         // privilege -> replacement list
+	Drop              []string          `json:"drop" yaml:"drop"`       // privileges to remove; ADMIN drops administrative ones
+	RemoveGrantOption bool              `json:"remove_grant_option" yaml:"remove_grant_option"`
+}
+
+// Auth modes decide how account passwords are set on a target.
+const (
+	AuthModeCopy    = "copy"    // reuse the source hash
+	AuthModeRotate  = "rotate"  // generate a new password per account
+	AuthModeSecrets = "secrets" // read cleartext passwords from credentials.secrets
+)
+
+// Missing-object policies decide what happens to grants on databases, tables, columns and
+// routines that do not exist on a target.
+const (
+	MissingObjectsFail         = "fail"                // the account fails
+	MissingObjectsSkipGrant    = "skip-grant"          // the grant is skipped and reported
+	MissingObjectsCreateSchema = "create-empty-schema" // missing databases of database-level grants are created; otherwise fail
+)
+
+// Partial-user policies decide what happens to an account when some of its grants failed.
+const (
+	OnPartialKeep     = "keep"     // leave the account as far as it got
+	OnPartialRollback = "rollback" // drop the account if this run created it
+)
+
+// Retry controls how statements and target connections are retried after transient MySQL
+// errors such as lock wait timeouts, deadlocks and lost connections. Zero values fall back
+// to defaults; Attempts of 1 disables retries.
+type Retry struct {
+	Attempts     int      `json:"attempts" yaml:"attempts"`           // tries per statement or connection
+	InitialDelay Duration `json:"initial_delay" yaml:"initial_delay"` // backoff before the first retry, doubled after each
+	MaxDelay     Duration `json:"max_delay" yaml:"max_delay"`
+}
+
+// Timeouts bound how long the run waits. Zero values mean no limit, except Connect, which
+// defaults to 5s.
+type Timeouts struct {
+	Connect   Duration `json:"connect" yaml:"connect"`     // each connection attempt, including the ping
+	Statement Duration `json:"statement" yaml:"statement"` // each statement and existence check for an account
+	Target    Duration `json:"target" yaml:"target"`       // everything done on one target
+	Run       Duration `json:"run" yaml:"run"`             // the whole run; accounts not started in time are not applied
+}
+
+func (t Timeouts) validate() error {
+	if t.Connect < 0 || t.Statement < 0 || t.Target < 0 || t.Run < 0 {
+		return errors.New("timeouts must not be negative")
+	}
+	return nil
+}
+
+// Override returns t with the non-zero values of o.
+func (t Timeouts) Override(o Timeouts) Timeouts {
+	if o.Connect != 0 {
+		t.Connect = o.Connect
+	}
+	if o.Statement != 0 {
+		t.Statement = o.Statement
+	}
+	if o.Target != 0 {
+		t.Target = o.Target
+	}
+	if o.Run != 0 {
+		t.Run = o.Run
+	}
+	return t
+}
+
+// Duration is a time.Duration written as a string such as "500ms" or "2m".
+type Duration time.Duration
+
+func (d *Duration) set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// UnmarshalJSON accepts a duration string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"500ms\": %w", err)
+	}
+	return d.set(s)
+}
+
+// UnmarshalYAML accepts a duration string.
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	return d.set(node.Value)
+}
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Credentials controls how passwords are set on targets.
+type Credentials struct {
+	Mode    string         `json:"mode" yaml:"mode"`
+	Policy  PasswordPolicy `json:"policy" yaml:"policy"`
+	Sink    CredentialSink `json:"sink" yaml:"sink"`
+	Secrets SecretsSource  `json:"secrets" yaml:"secrets"`
+}
+
+// SecretsSource supplies cleartext passwords. File is a YAML mapping of user@host (or user) to
+// password, optionally sealed with Encryption. Command runs through sh -c with MUM_TARGET,
+// MUM_USER and MUM_HOST set and prints the password; it is consulted when the file has no entry.
+type SecretsSource struct {
+	File       string     `json:"file" yaml:"file"`
+	Command    string     `json:"command" yaml:"command"`
+	Encryption Encryption `json:"encryption" yaml:"encryption"`
+}
+
+// PasswordPolicy constrains generated passwords. Zero values fall back to defaults.
+type PasswordPolicy struct {
+	Length     int    `json:"length" yaml:"length"`
+	MinLower   int    `json:"min_lower" yaml:"min_lower"`
+	MinUpper   int    `json:"min_upper" yaml:"min_upper"`
+	MinDigits  int    `json:"min_digits" yaml:"min_digits"`
+	MinSymbols int    `json:"min_symbols" yaml:"min_symbols"`
+	Symbols    string `json:"symbols" yaml:"symbols"`
+}
+
+// CredentialSink receives generated credentials.
+type CredentialSink struct {
+	Type       string     `json:"type" yaml:"type"` // encrypted-file, files, kubernetes or http
+	Path       string     `json:"path" yaml:"path"`
+	Namespace  string     `json:"namespace" yaml:"namespace"` // kubernetes
+	URL        string     `json:"url" yaml:"url"`             // http
+	TokenEnv   string     `json:"token_env" yaml:"token_env"` // http bearer token variable
+	Encryption Encryption `json:"encryption" yaml:"encryption"`
+}
+
+// Encryption selects the key used for encrypted artifacts: a passphrase read from an
+// environment variable, or a file holding a 32-byte key (raw, hex or base64).
+type Encryption struct {
+	PassphraseEnv string `json:"passphrase_env" yaml:"passphrase_env"`
+	KeyFile       string `json:"key_file" yaml:"key_file"`
 }
 
 // FileConfig represents configuration loaded from a YAML/JSON file.
 type FileConfig struct {
-	Source         string   `json:"source" yaml:"source"`
-	Targets        []Target `json:"targets" yaml:"targets"`
-	Include        []string `json:"include" yaml:"include"`
-	Exclude        []string `json:"exclude" yaml:"exclude"`
-	DryRun         bool     `json:"dry_run" yaml:"dry_run"`
-	DropMissing    bool     `json:"drop_missing" yaml:"drop_missing"`
-	ForceOverwrite bool     `json:"force_overwrite" yaml:"force_overwrite"`
-	ReportPath     string   `json:"report_path" yaml:"report_path"`
-	Concurrency    int      `json:"concurrency" yaml:"concurrency"`
-	Verbose        bool     `json:"verbose" yaml:"verbose"`
+	Source         string          `json:"source" yaml:"source"`
+	SourceSnapshot string          `json:"source_snapshot" yaml:"source_snapshot"`
+	SourceScripts  []string        `json:"source_scripts" yaml:"source_scripts"` // mysqlpump / MySQL Shell user scripts
+	SourceDump     []string        `json:"source_dump" yaml:"source_dump"`       // mysqldump of the mysql schema
+	SourceDir      string          `json:"source_dir" yaml:"source_dir"`         // desired-state account files
+	Targets        []Target        `json:"targets" yaml:"targets"`
+	Include        []string        `json:"include" yaml:"include"`
+	Exclude        []string        `json:"exclude" yaml:"exclude"`
+	UserMap        []UserMapping   `json:"user_map" yaml:"user_map"`
+	PrivilegeRules []PrivilegeRule `json:"privilege_rules" yaml:"privilege_rules"`
+	Credentials    Credentials     `json:"credentials" yaml:"credentials"`
+	Encryption     Encryption      `json:"encryption" yaml:"encryption"`           // snapshots and plan files
+	MissingObjects string          `json:"missing_objects" yaml:"missing_objects"` // fail (default), skip-grant or create-empty-schema
+	OnPartial      string          `json:"on_partial" yaml:"on_partial"`           // keep (default) or rollback
+	BackupDir      string          `json:"backup_dir" yaml:"backup_dir"`           // undo scripts taken before changes
+	RollbackOnFail bool            `json:"rollback_on_failure" yaml:"rollback_on_failure"`
+	Journal        string          `json:"journal" yaml:"journal"` // account outcomes, for resuming
+	Retry          Retry           `json:"retry" yaml:"retry"`
+	Timeouts       Timeouts        `json:"timeouts" yaml:"timeouts"`
+	DryRun         bool            `json:"dry_run" yaml:"dry_run"`
+	Precheck       bool            `json:"precheck" yaml:"precheck"` // block targets missing privileges the plan needs
+	DropMissing    bool            `json:"drop_missing" yaml:"drop_missing"`
+	ForceOverwrite bool            `json:"force_overwrite" yaml:"force_overwrite"`
+	ReportPath     string          `json:"report_path" yaml:"report_path"`
+	SQLDir         string          `json:"sql_dir" yaml:"sql_dir"`
+	Concurrency    int             `json:"concurrency" yaml:"concurrency"`
+	Verbose        bool            `json:"verbose" yaml:"verbose"`
 }
 
 // CLIConfig captures values provided via command-line flags (which may be unset).
 type CLIConfig struct {
 	Source         string
+	SourceSnapshot string
+	SourceScripts  []string
+	SourceDump     []string
+	SourceDir      string
 	Targets        []Target
 	Include        []string
 	Exclude        []string
+	UserMap        []UserMapping
+	Encryption     Encryption
+	MissingObjects string
+	OnPartial      string
+	BackupDir      string
+	RollbackOnFail *bool
+	Journal        string
+	Resume         string
+	RetryAttempts  *int
+	Timeouts       Timeouts
 	DryRun         *bool
+	Precheck       *bool
 	DropMissing    *bool
 	ForceOverwrite *bool
 	ReportPath     string
+	SQLDir         string
 	Concurrency    *int
 	Verbose        *bool
 }
@@ -47,13 +248,31 @@ type CLIConfig struct {
 // RuntimeConfig is the fully merged, validated configuration.
 type RuntimeConfig struct {
 	Source         string
+	SourceSnapshot string
+	SourceScripts  []string
+	SourceDump     []string
+	SourceDir      string
 	Targets        []Target
 	Include        []string
 	Exclude        []string
+	UserMap        []UserMapping
+	PrivilegeRules []PrivilegeRule
+	Credentials    Credentials
+	Encryption     Encryption
+	MissingObjects string
+	OnPartial      string
+	BackupDir      string
+	RollbackOnFail bool
+	Journal        string
+	Resume         string // journal of an earlier run to continue
+	Retry          Retry
+	Timeouts       Timeouts
 	DryRun         bool
+	Precheck       bool
 	DropMissing    bool
 	ForceOverwrite bool
 	ReportPath     string
+	SQLDir         string
 	Concurrency    int
 	Verbose        bool
 }
@@ -85,19 +304,41 @@ func Load(path string) (FileConfig, error) {
 func Merge(fileCfg FileConfig, cliCfg CLIConfig) RuntimeConfig {
 	out := RuntimeConfig{
 		Source:         fileCfg.Source,
+		SourceSnapshot: fileCfg.SourceSnapshot,
+		SourceScripts:  append([]string(nil), fileCfg.SourceScripts...),
+		SourceDump:     append([]string(nil), fileCfg.SourceDump...),
+		SourceDir:      fileCfg.SourceDir,
 		Targets:        append([]Target(nil), fileCfg.Targets...),
 		Include:        append([]string(nil), fileCfg.Include...),
 		Exclude:        append([]string(nil), fileCfg.Exclude...),
+		UserMap:        append([]UserMapping(nil), fileCfg.UserMap...),
+		PrivilegeRules: append([]PrivilegeRule(nil), fileCfg.PrivilegeRules...),
+		Credentials:    fileCfg.Credentials,
+		Encryption:     fileCfg.Encryption,
+		MissingObjects: fileCfg.MissingObjects,
+		OnPartial:      fileCfg.OnPartial,
+		BackupDir:      fileCfg.BackupDir,
+		RollbackOnFail: fileCfg.RollbackOnFail,
+		Journal:        fileCfg.Journal,
+		Retry:          fileCfg.Retry,
+		Timeouts:       fileCfg.Timeouts,
 		DryRun:         fileCfg.DryRun,
+		Precheck:       fileCfg.Precheck,
 		DropMissing:    fileCfg.DropMissing,
 		ForceOverwrite: fileCfg.ForceOverwrite,
 		ReportPath:     fileCfg.ReportPath,
+		SQLDir:         fileCfg.SQLDir,
 		Concurrency:    fileCfg.Concurrency,
 		Verbose:        fileCfg.Verbose,
 	}
 
-	if cliCfg.Source != "" {
+	// A source given on the command line replaces any kind of source from the file.
+	if cliCfg.Source != "" || cliCfg.SourceSnapshot != "" || len(cliCfg.SourceScripts) > 0 || len(cliCfg.SourceDump) > 0 || cliCfg.SourceDir != "" {
 		out.Source = cliCfg.Source
+		out.SourceSnapshot = cliCfg.SourceSnapshot
+		out.SourceScripts = cliCfg.SourceScripts
+		out.SourceDump = cliCfg.SourceDump
+		out.SourceDir = cliCfg.SourceDir
 	}
 	if len(cliCfg.Targets) > 0 {
 		out.Targets = cliCfg.Targets
@@ -108,9 +349,40 @@ func Merge(fileCfg FileConfig, cliCfg CLIConfig) RuntimeConfig {
 	if len(cliCfg.Exclude) > 0 {
 		out.Exclude = cliCfg.Exclude
 	}
+	if cliCfg.Encryption.PassphraseEnv != "" || cliCfg.Encryption.KeyFile != "" {
+		out.Encryption = cliCfg.Encryption
+	}
+	if len(cliCfg.UserMap) > 0 {
+		out.UserMap = cliCfg.UserMap
+	}
+	if cliCfg.MissingObjects != "" {
+		out.MissingObjects = cliCfg.MissingObjects
+	}
+	if cliCfg.OnPartial != "" {
+		out.OnPartial = cliCfg.OnPartial
+	}
+	if cliCfg.BackupDir != "" {
+		out.BackupDir = cliCfg.BackupDir
+	}
+	if cliCfg.RollbackOnFail != nil {
+		out.RollbackOnFail = *cliCfg.RollbackOnFail
+	}
+	if cliCfg.Journal != "" {
+		out.Journal = cliCfg.Journal
+	}
+	if cliCfg.Resume != "" {
+		out.Resume = cliCfg.Resume
+	}
+	out.Timeouts = out.Timeouts.Override(cliCfg.Timeouts)
+	if cliCfg.RetryAttempts != nil {
+		out.Retry.Attempts = *cliCfg.RetryAttempts
+	}
 	if cliCfg.DryRun != nil {
 		out.DryRun = *cliCfg.DryRun
 	}
+	if cliCfg.Precheck != nil {
+		out.Precheck = *cliCfg.Precheck
+	}
 	if cliCfg.DropMissing != nil {
 		out.DropMissing = *cliCfg.DropMissing
 	}
@@ -120,6 +392,9 @@ func Merge(fileCfg FileConfig, cliCfg CLIConfig) RuntimeConfig {
 	if cliCfg.ReportPath != "" {
 		out.ReportPath = cliCfg.ReportPath
 	}
+	if cliCfg.SQLDir != "" {
+		out.SQLDir = cliCfg.SQLDir
+	}
 	if cliCfg.Concurrency != nil {
 		out.Concurrency = *cliCfg.Concurrency
 	}
@@ -129,10 +404,36 @@ func Merge(fileCfg FileConfig, cliCfg CLIConfig) RuntimeConfig {
 	return out
 }
 
+// ValidateSource ensures a source is configured, for commands that do not touch targets.
+func (c *RuntimeConfig) ValidateSource() error {
+	switch n := c.sourceCount(); {
+	case n == 0:
+		return errors.New("missing source DSN, snapshot, SQL scripts, dump or desired-state directory (flag or config)")
+	case n > 1:
+		return errors.New("source, source_snapshot, source_scripts, source_dump and source_dir are mutually exclusive")
+	}
+	return nil
+}
+
+// HasSource reports whether any kind of source is configured.
+func (c *RuntimeConfig) HasSource() bool {
+	return c.sourceCount() > 0
+}
+
+func (c *RuntimeConfig) sourceCount() int {
+	n := 0
+	for _, set := range []bool{c.Source != "", c.SourceSnapshot != "", len(c.SourceScripts) > 0, len(c.SourceDump) > 0, c.SourceDir != ""} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
 // Validate ensures required fields are present and fill defaults.
 func (c *RuntimeConfig) Validate() error {
-	if c.Source == "" {
-		return errors.New("missing source DSN (flag or config)")
+	if err := c.ValidateSource(); err != nil {
+		return err
 	}
 	if len(c.Targets) == 0 {
 		return errors.New("missing at least one target DSN")
@@ -140,5 +441,62 @@ func (c *RuntimeConfig) Validate() error {
 	if c.Concurrency <= 0 {
 		c.Concurrency = 1
 	}
+	if c.SQLDir != "" && c.DryRun {
+		return errors.New("sql_dir writes scripts instead of executing; do not combine it with dry_run")
+	}
+	if (c.Journal != "" || c.Resume != "") && (c.DryRun || c.SQLDir != "") {
+		return errors.New("journal and resume record executed changes; do not combine them with dry_run or sql_dir")
+	}
+	if c.RollbackOnFail && c.SQLDir != "" {
+		return errors.New("rollback_on_failure undoes executed changes; do not combine it with sql_dir")
+	}
+	if c.Retry.Attempts < 0 || c.Retry.InitialDelay < 0 || c.Retry.MaxDelay < 0 {
+		return errors.New("retry attempts and delays must not be negative")
+	}
+	if err := c.Timeouts.validate(); err != nil {
+		return err
+	}
+	switch c.OnPartial {
+	case "", OnPartialKeep, OnPartialRollback:
+	default:
+		return fmt.Errorf("unknown on_partial %q (keep or rollback)", c.OnPartial)
+	}
+	var rotate, secrets bool
+	for _, t := range c.Targets {
+		if err := t.Timeouts.validate(); err != nil {
+			return fmt.Errorf("target %s: %w", t.Name, err)
+		}
+		if t.Timeouts.Run != 0 {
+			return fmt.Errorf("target %s: the run timeout applies to the whole run; set target instead", t.Name)
+		}
+		mode := t.AuthMode
+		if mode == "" {
+			mode = c.Credentials.Mode
+		}
+		switch mode {
+		case "", AuthModeCopy:
+		case AuthModeRotate:
+			rotate = true
+		case AuthModeSecrets:
+			secrets = true
+		default:
+			return fmt.Errorf("target %s: unknown auth mode %q", t.Name, mode)
+		}
+		policy := t.MissingObjects
+		if policy == "" {
+			policy = c.MissingObjects
+		}
+		switch policy {
+		case "", MissingObjectsFail, MissingObjectsSkipGrant, MissingObjectsCreateSchema:
+		default:
+			return fmt.Errorf("target %s: unknown missing_objects policy %q", t.Name, policy)
+		}
+	}
+	if rotate && c.Credentials.Sink.Type == "" && !c.DryRun {
+		return errors.New("auth mode rotate requires credentials.sink")
+	}
+	if secrets && c.Credentials.Secrets.File == "" && c.Credentials.Secrets.Command == "" {
+		return errors.New("auth mode secrets requires credentials.secrets.file or command")
+	}
 	return nil
 }
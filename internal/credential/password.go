@@ -0,0 +1,91 @@
+// Package credential generates account passwords and delivers them to credential sinks.
+package credential
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+)
+
+const (
+	lowerChars     = "abcdefghijklmnopqrstuvwxyz"
+	upperChars     = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitChars     = "0123456789"
+	defaultSymbols = "!#%+-.:=@^_~"
+	defaultLength  = 24
+)
+
+// Credential is a generated password for one account on one target.
+type Credential struct {
+	Target   string `json:"target" yaml:"target"`
+	User     string `json:"user" yaml:"user"`
+	Host     string `json:"host" yaml:"host"`
+	Password string `json:"password" yaml:"password"`
+}
+
+// Generate returns a random password that satisfies policy.
+func Generate(policy config.PasswordPolicy) (string, error) {
+	policy = withDefaults(policy)
+	required := policy.MinLower + policy.MinUpper + policy.MinDigits + policy.MinSymbols
+	if required > policy.Length {
+		return "", fmt.Errorf("password policy requires %d classed characters but length is %d", required, policy.Length)
+	}
+	if policy.MinSymbols > 0 && policy.Symbols == "" {
+		return "", errors.New("password policy requires symbols but none are allowed")
+	}
+
+	all := lowerChars + upperChars + digitChars + policy.Symbols
+	var out []byte
+	for _, class := range []struct {
+		chars string
+		n     int
+	}{
+		{lowerChars, policy.MinLower},
+		{upperChars, policy.MinUpper},
+		{digitChars, policy.MinDigits},
+		{policy.Symbols, policy.MinSymbols},
+		{all, policy.Length - required},
+	} {
+		for i := 0; i < class.n; i++ {
+			c, err := pick(class.chars)
+			if err != nil {
+				return "", err
+			}
+			out = append(out, c)
+		}
+	}
+
+	// Fisher-Yates so the classed characters do not sit at fixed positions.
+	for i := len(out) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		out[i], out[j.Int64()] = out[j.Int64()], out[i]
+	}
+	return string(out), nil
+}
+
+func withDefaults(p config.PasswordPolicy) config.PasswordPolicy {
+	if p.Length <= 0 {
+		p.Length = defaultLength
+		if p.MinLower == 0 && p.MinUpper == 0 && p.MinDigits == 0 && p.MinSymbols == 0 {
+			p.MinLower, p.MinUpper, p.MinDigits, p.MinSymbols = 1, 1, 1, 1
+		}
+	}
+	if p.Symbols == "" {
+		p.Symbols = defaultSymbols
+	}
+	return p
+}
+
+func pick(chars string) (byte, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+	if err != nil {
+		return 0, fmt.Errorf("generate password: %w", err)
+	}
+	return chars[n.Int64()], nil
+}
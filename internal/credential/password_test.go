@@ -0,0 +1,35 @@
+package credential
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+)
+
+func TestGenerate(t *testing.T) {
+	policy := config.PasswordPolicy{Length: 16, MinUpper: 3, MinDigits: 4, MinSymbols: 2, Symbols: "#@"}
+	pw, err := Generate(policy)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if len(pw) != 16 {
+		t.Fatalf("len = %d, want 16", len(pw))
+	}
+	count := func(chars string) int {
+		n := 0
+		for _, c := range pw {
+			if strings.ContainsRune(chars, c) {
+				n++
+			}
+		}
+		return n
+	}
+	if count(upperChars) < 3 || count(digitChars) < 4 || count("#@") < 2 {
+		t.Fatalf("password %q does not satisfy policy %+v", pw, policy)
+	}
+
+	if _, err := Generate(config.PasswordPolicy{Length: 4, MinDigits: 5}); err == nil {
+		t.Fatalf("expected error for unsatisfiable policy")
+	}
+}
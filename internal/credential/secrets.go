@@ -0,0 +1,91 @@
+package credential
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+	"github.com/raojinlin/mysql-user-migrate/internal/crypt"
+)
+
+// Sources reported by Secrets.Lookup.
+const (
+	SourceFile    = "secret-file"
+	SourceCommand = "secret-command"
+)
+
+// Secrets resolves cleartext account passwords from a mapping file and/or a command.
+type Secrets struct {
+	entries map[string]string
+	command string
+}
+
+// LoadSecrets reads the mapping file, decrypting it when it is sealed. With a key configured
+// the file must be sealed.
+func LoadSecrets(cfg config.SecretsSource) (*Secrets, error) {
+	s := &Secrets{command: cfg.Command}
+	if cfg.File == "" {
+		return s, nil
+	}
+	key, err := crypt.LoadKey(cfg.Encryption.PassphraseEnv, cfg.Encryption.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("secrets key: %w", err)
+	}
+	data, err := crypt.ReadFile(cfg.File, key)
+	if err != nil {
+		return nil, fmt.Errorf("read secrets: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &s.entries); err != nil {
+		return nil, fmt.Errorf("parse secrets: %w", err)
+	}
+	return s, nil
+}
+
+// Lookup returns the password for user@host and where it came from. An empty source with a nil
+// error means no secret exists for the account.
+func (s *Secrets) Lookup(ctx context.Context, target, user, host string) (string, string, error) {
+	for _, key := range []string{user + "@" + host, user} {
+		if pw, ok := s.entries[key]; ok {
+			return pw, SourceFile, nil
+		}
+	}
+	return s.run(ctx, "MUM_TARGET="+target, "MUM_USER="+user, "MUM_HOST="+host)
+}
+
+// LookupRef returns the password stored under a named reference, as used by desired-state
+// files. The command, if any, additionally receives the reference in MUM_SECRET.
+func (s *Secrets) LookupRef(ctx context.Context, target, ref, user, host string) (string, string, error) {
+	if pw, ok := s.entries[ref]; ok {
+		return pw, SourceFile, nil
+	}
+	return s.run(ctx, "MUM_TARGET="+target, "MUM_USER="+user, "MUM_HOST="+host, "MUM_SECRET="+ref)
+}
+
+// run executes the secrets command, if configured, and returns its output as the password.
+func (s *Secrets) run(ctx context.Context, env ...string) (string, string, error) {
+	if s.command == "" {
+		return "", "", nil
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", s.command)
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// stderr is reported but stdout is not, as it may hold a partial secret.
+		return "", "", fmt.Errorf("secrets command: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	pw := strings.TrimRight(string(out), "\r\n")
+	if pw == "" {
+		return "", "", errors.New("secrets command printed no password")
+	}
+	return pw, SourceCommand, nil
+}
@@ -0,0 +1,88 @@
+package credential
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+	"github.com/raojinlin/mysql-user-migrate/internal/crypt"
+)
+
+func TestSecretsLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.yaml")
+	if err := os.WriteFile(path, []byte("app@10.0.%: from-file\nreport: any-host\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSecrets(config.SecretsSource{File: path, Command: `echo "cmd-$MUM_USER"`})
+	if err != nil {
+		t.Fatalf("LoadSecrets: %v", err)
+	}
+
+	tests := []struct {
+		user, host   string
+		want, source string
+	}{
+		{"app", "10.0.%", "from-file", SourceFile},
+		{"report", "localhost", "any-host", SourceFile},
+		{"batch", "%", "cmd-batch", SourceCommand},
+	}
+	for _, tt := range tests {
+		pw, source, err := s.Lookup(context.Background(), "stg", tt.user, tt.host)
+		if err != nil || pw != tt.want || source != tt.source {
+			t.Errorf("Lookup(%s@%s) = %q, %q, %v; want %q, %q", tt.user, tt.host, pw, source, err, tt.want, tt.source)
+		}
+	}
+}
+
+func TestSecretsLookupRef(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.yaml")
+	if err := os.WriteFile(path, []byte("app-prod: from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSecrets(config.SecretsSource{File: path, Command: `echo "cmd-$MUM_SECRET-$MUM_USER"`})
+	if err != nil {
+		t.Fatalf("LoadSecrets: %v", err)
+	}
+	if pw, source, err := s.LookupRef(context.Background(), "stg", "app-prod", "app", "%"); err != nil || pw != "from-file" || source != SourceFile {
+		t.Errorf("LookupRef(app-prod) = %q, %q, %v", pw, source, err)
+	}
+	if pw, source, err := s.LookupRef(context.Background(), "stg", "vault/batch", "batch", "%"); err != nil || pw != "cmd-vault/batch-batch" || source != SourceCommand {
+		t.Errorf("LookupRef(vault/batch) = %q, %q, %v", pw, source, err)
+	}
+}
+
+func TestLoadSecretsSealed(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := crypt.LoadKey("", keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.SecretsSource{File: filepath.Join(dir, "secrets.yaml"), Encryption: config.Encryption{KeyFile: keyFile}}
+
+	// With a key, a plaintext file is not trusted.
+	if err := os.WriteFile(cfg.File, []byte("app: plain\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSecrets(cfg); !errors.Is(err, crypt.ErrNotSealed) {
+		t.Fatalf("LoadSecrets(plaintext) error = %v", err)
+	}
+
+	if err := crypt.WriteFile(cfg.File, []byte("app: sealed\n"), key); err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSecrets(cfg)
+	if err != nil {
+		t.Fatalf("LoadSecrets: %v", err)
+	}
+	if pw, source, err := s.Lookup(context.Background(), "stg", "app", "%"); err != nil || pw != "sealed" || source != SourceFile {
+		t.Errorf("Lookup(app) = %q, %q, %v", pw, source, err)
+	}
+}
@@ -0,0 +1,243 @@
+package credential
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+	"github.com/raojinlin/mysql-user-migrate/internal/crypt"
+)
+
+// Sink receives generated credentials. Implementations must be safe for concurrent use and
+// must have stored a credential when Put returns, since the password is set right after.
+type Sink interface {
+	Put(ctx context.Context, cred Credential) error
+	Close() error
+}
+
+// NewSink builds the sink described by cfg.
+func NewSink(cfg config.CredentialSink) (Sink, error) {
+	switch cfg.Type {
+	case "encrypted-file":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("sink %s: path is required", cfg.Type)
+		}
+		key, err := crypt.LoadKey(cfg.Encryption.PassphraseEnv, cfg.Encryption.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %w", cfg.Type, err)
+		}
+		if key == nil {
+			return nil, fmt.Errorf("sink %s: encryption.passphrase_env or encryption.key_file is required", cfg.Type)
+		}
+		return &encryptedFileSink{path: cfg.Path, key: key}, nil
+	case "files":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("sink %s: path is required", cfg.Type)
+		}
+		return &filesSink{dir: cfg.Path}, nil
+	case "kubernetes":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("sink %s: path is required", cfg.Type)
+		}
+		return &kubernetesSink{path: cfg.Path, namespace: cfg.Namespace}, nil
+	case "http":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("sink %s: url is required", cfg.Type)
+		}
+		sink := &httpSink{url: cfg.URL, client: &http.Client{Timeout: 10 * time.Second}}
+		if cfg.TokenEnv != "" {
+			sink.token = os.Getenv(cfg.TokenEnv)
+		}
+		return sink, nil
+	}
+	return nil, fmt.Errorf("unknown credential sink type %q", cfg.Type)
+}
+
+// encryptedFileSink keeps all credentials in one sealed YAML document, rewritten on every Put
+// so a password is on disk before it is set on a target.
+type encryptedFileSink struct {
+	path  string
+	key   *crypt.Key
+	mu    sync.Mutex
+	creds []Credential
+}
+
+func (s *encryptedFileSink) Put(_ context.Context, cred Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	creds := append(s.creds[:len(s.creds):len(s.creds)], cred)
+	data, err := yaml.Marshal(creds)
+	if err != nil {
+		return fmt.Errorf("marshal credentials: %w", err)
+	}
+	sealed, err := crypt.Seal(data, s.key)
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(s.path, sealed); err != nil {
+		return fmt.Errorf("write credentials: %w", err)
+	}
+	s.creds = creds
+	return nil
+}
+
+func (s *encryptedFileSink) Close() error { return nil }
+
+// filesSink writes one file per account as soon as it is generated.
+type filesSink struct {
+	dir string
+}
+
+func (s *filesSink) Put(_ context.Context, cred Credential) error {
+	dir := filepath.Join(s.dir, fileSafe(cred.Target))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	name := fileSafe(cred.User + "@" + cred.Host)
+	return os.WriteFile(filepath.Join(dir, name), []byte(cred.Password+"\n"), 0o600)
+}
+
+func (s *filesSink) Close() error { return nil }
+
+// kubernetesSink writes one Opaque Secret manifest per account to a multi-document YAML file,
+// rewritten on every Put.
+type kubernetesSink struct {
+	path      string
+	namespace string
+	mu        sync.Mutex
+	creds     []Credential
+}
+
+func (s *kubernetesSink) Put(_ context.Context, cred Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	creds := append(s.creds[:len(s.creds):len(s.creds)], cred)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	for _, cred := range creds {
+		metadata := map[string]any{
+			"name":   secretName(cred),
+			"labels": map[string]string{"app.kubernetes.io/managed-by": "mysql-user-migrate"},
+		}
+		if s.namespace != "" {
+			metadata["namespace"] = s.namespace
+		}
+		manifest := map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"type":       "Opaque",
+			"metadata":   metadata,
+			"stringData": map[string]string{
+				"target":   cred.Target,
+				"username": cred.User,
+				"host":     cred.Host,
+				"password": cred.Password,
+			},
+		}
+		if err := enc.Encode(manifest); err != nil {
+			return fmt.Errorf("encode secret: %w", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if err := replaceFile(s.path, buf.Bytes()); err != nil {
+		return fmt.Errorf("write secrets: %w", err)
+	}
+	s.creds = creds
+	return nil
+}
+
+func (s *kubernetesSink) Close() error { return nil }
+
+// httpSink posts each credential as JSON to a secret-store endpoint.
+type httpSink struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (s *httpSink) Put(ctx context.Context, cred Credential) error {
+	body, err := json.Marshal(cred)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post credential: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("post credential: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error { return nil }
+
+// replaceFile writes data to a temporary file next to path and renames it over path, so a
+// crash never leaves a truncated file behind.
+func replaceFile(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// fileSafe replaces path separators so account names can be used as file names.
+func fileSafe(s string) string {
+	return strings.NewReplacer("/", "_", `\`, "_", "\x00", "_").Replace(s)
+}
+
+// secretName builds a DNS-1123 compatible name for the account's Secret.
+func secretName(cred Credential) string {
+	raw := strings.ToLower(fmt.Sprintf("mysql-%s-%s-%s", cred.Target, cred.User, cred.Host))
+	var b strings.Builder
+	dash := false
+	for _, r := range raw {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	name := strings.Trim(b.String(), "-")
+	if len(name) > 253 {
+		name = strings.Trim(name[:253], "-")
+	}
+	return name
+}
@@ -0,0 +1,104 @@
+package credential
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/crypt"
+)
+
+func TestSinksPersistOnPut(t *testing.T) {
+	key := crypt.Passphrase("correct horse")
+	dir := t.TempDir()
+	sealed := filepath.Join(dir, "creds.yaml.enc")
+	secrets := filepath.Join(dir, "secrets.yaml")
+	sinks := map[string]Sink{
+		"encrypted-file": &encryptedFileSink{path: sealed, key: key},
+		"kubernetes":     &kubernetesSink{path: secrets, namespace: "db"},
+	}
+	read := map[string]func() string{
+		"encrypted-file": func() string {
+			data, err := crypt.ReadFile(sealed, key)
+			if err != nil {
+				t.Fatalf("read %s: %v", sealed, err)
+			}
+			var creds []Credential
+			if err := yaml.Unmarshal(data, &creds); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			var passwords []string
+			for _, c := range creds {
+				passwords = append(passwords, c.Password)
+			}
+			return strings.Join(passwords, ",")
+		},
+		"kubernetes": func() string {
+			data, err := os.ReadFile(secrets)
+			if err != nil {
+				t.Fatalf("read %s: %v", secrets, err)
+			}
+			return string(data)
+		},
+	}
+
+	for name, sink := range sinks {
+		// Each password must be stored before Put returns, without waiting for Close.
+		for _, cred := range []Credential{
+			{Target: "t1", User: "app", Host: "%", Password: "first-pw"},
+			{Target: "t1", User: "web", Host: "%", Password: "second-pw"},
+		} {
+			if err := sink.Put(context.Background(), cred); err != nil {
+				t.Fatalf("%s: Put: %v", name, err)
+			}
+			if got := read[name](); !strings.Contains(got, cred.Password) {
+				t.Fatalf("%s: %s not stored after Put:\n%s", name, cred.User, got)
+			}
+		}
+		if got := read[name](); !strings.Contains(got, "first-pw") {
+			t.Fatalf("%s: earlier credential lost:\n%s", name, got)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("%s: Close: %v", name, err)
+		}
+	}
+
+	raw, err := os.ReadFile(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !crypt.IsSealed(raw) || strings.Contains(string(raw), "first-pw") {
+		t.Fatalf("credentials file is not sealed")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Fatalf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestSinkPutFailureKeepsEarlierCredentials(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secrets.yaml")
+	sink := &kubernetesSink{path: path}
+	if err := sink.Put(context.Background(), Credential{Target: "t1", User: "app", Host: "%", Password: "kept"}); err != nil {
+		t.Fatal(err)
+	}
+	// A directory in place of the file makes the rename fail.
+	sink.path = dir
+	if err := sink.Put(context.Background(), Credential{Target: "t1", User: "new", Host: "%", Password: "lost"}); err == nil {
+		t.Fatalf("expected Put to fail")
+	}
+	if len(sink.creds) != 1 {
+		t.Fatalf("failed credential was kept: %d credentials", len(sink.creds))
+	}
+}
+
+func TestSecretName(t *testing.T) {
+	got := secretName(Credential{Target: "Vendor_Sandbox", User: "app", Host: "10.0.%"})
+	if want := "mysql-vendor-sandbox-app-10-0"; got != want {
+		t.Fatalf("secretName = %s, want %s", got, want)
+	}
+}
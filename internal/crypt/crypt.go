@@ -0,0 +1,229 @@
+// Package crypt provides authenticated encryption for artifacts that contain secrets.
+//
+// Sealed data is AES-256-GCM with a versioned header. Keys come either from a passphrase
+// (stretched with PBKDF2-HMAC-SHA256 and a random salt) or from a 32-byte key file.
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	magic      = "MUMENC1"
+	kdfRaw     = byte(0)
+	kdfPBKDF2  = byte(1)
+	saltSize   = 16
+	keySize    = 32
+	iterations = 600000
+	headerSize = len(magic) + 1 + saltSize
+)
+
+// ErrTampered is returned when sealed data fails authentication.
+var ErrTampered = errors.New("authentication failed: wrong key or tampered data")
+
+// ErrNotSealed is returned when a key is configured but a file is not encrypted.
+var ErrNotSealed = errors.New("file is not encrypted")
+
+// Key is the secret used to seal and open data.
+type Key struct {
+	passphrase []byte
+	raw        []byte
+}
+
+// Passphrase returns a key derived from a passphrase.
+func Passphrase(p string) *Key {
+	return &Key{passphrase: []byte(p)}
+}
+
+// RawKey returns a key that uses 32 bytes of key material directly.
+func RawKey(b []byte) (*Key, error) {
+	if len(b) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", keySize, len(b))
+	}
+	return &Key{raw: append([]byte(nil), b...)}, nil
+}
+
+// LoadKey resolves a key from a passphrase environment variable or a key file. It returns nil
+// when neither is configured.
+func LoadKey(passphraseEnv, keyFile string) (*Key, error) {
+	switch {
+	case keyFile != "":
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read key file: %w", err)
+		}
+		return parseKeyFile(data)
+	case passphraseEnv != "":
+		p := os.Getenv(passphraseEnv)
+		if p == "" {
+			return nil, fmt.Errorf("passphrase variable %s is empty", passphraseEnv)
+		}
+		return Passphrase(p), nil
+	}
+	return nil, nil
+}
+
+// parseKeyFile accepts 32 raw bytes, or 32 bytes encoded as hex or base64.
+func parseKeyFile(data []byte) (*Key, error) {
+	if len(data) == keySize {
+		return RawKey(data)
+	}
+	text := strings.TrimSpace(string(data))
+	if b, err := hex.DecodeString(text); err == nil && len(b) == keySize {
+		return RawKey(b)
+	}
+	if b, err := base64.StdEncoding.DecodeString(text); err == nil && len(b) == keySize {
+		return RawKey(b)
+	}
+	return nil, fmt.Errorf("key file must contain %d bytes (raw, hex or base64)", keySize)
+}
+
+// IsSealed reports whether data carries the sealed header.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(magic))
+}
+
+// Seal encrypts and authenticates plaintext.
+func Seal(plaintext []byte, key *Key) ([]byte, error) {
+	if key == nil {
+		return nil, errors.New("no encryption key configured")
+	}
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[len(magic)] = kdfRaw
+	if key.raw == nil {
+		header[len(magic)] = kdfPBKDF2
+		if _, err := rand.Read(header[len(magic)+1:]); err != nil {
+			return nil, fmt.Errorf("generate salt: %w", err)
+		}
+	}
+	aead, err := key.aead(header)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	out := append(header, nonce...)
+	return aead.Seal(out, nonce, plaintext, header), nil
+}
+
+// Open authenticates and decrypts data produced by Seal.
+func Open(data []byte, key *Key) ([]byte, error) {
+	if !IsSealed(data) {
+		return nil, errors.New("data is not encrypted")
+	}
+	if key == nil {
+		return nil, errors.New("data is encrypted but no decryption key is configured")
+	}
+	if len(data) < headerSize {
+		return nil, ErrTampered
+	}
+	header := data[:headerSize]
+	switch header[len(magic)] {
+	case kdfRaw:
+		if key.raw == nil {
+			return nil, errors.New("data was sealed with a key file, not a passphrase")
+		}
+	case kdfPBKDF2:
+		if key.raw != nil {
+			return nil, errors.New("data was sealed with a passphrase, not a key file")
+		}
+	default:
+		return nil, ErrTampered
+	}
+	aead, err := key.aead(header)
+	if err != nil {
+		return nil, err
+	}
+	rest := data[headerSize:]
+	if len(rest) < aead.NonceSize() {
+		return nil, ErrTampered
+	}
+	plaintext, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], header)
+	if err != nil {
+		return nil, ErrTampered
+	}
+	return plaintext, nil
+}
+
+func (k *Key) aead(header []byte) (cipher.AEAD, error) {
+	material := k.raw
+	if material == nil {
+		material = pbkdf2(k.passphrase, header[len(magic)+1:], iterations, keySize)
+	}
+	block, err := aes.NewCipher(material)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 implements PBKDF2 (RFC 8018) with HMAC-SHA256.
+func pbkdf2(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+	out := make([]byte, 0, blocks*hashLen)
+	buf := make([]byte, 4)
+	u := make([]byte, hashLen)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf, uint32(block))
+		prf.Write(buf)
+		u = prf.Sum(u[:0])
+		t := append([]byte(nil), u...)
+		for n := 1; n < iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:keyLen]
+}
+
+// WriteFile writes data to path readable by the owner only, sealing it when key is not nil.
+func WriteFile(path string, data []byte, key *Key) error {
+	if key != nil {
+		sealed, err := Seal(data, key)
+		if err != nil {
+			return err
+		}
+		data = sealed
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// ReadFile reads path and opens it when it is sealed. Unsealed files are returned as is only
+// without a key: with one, a plaintext file fails with ErrNotSealed rather than being trusted.
+func ReadFile(path string, key *Key) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case IsSealed(data):
+		return Open(data, key)
+	case key != nil:
+		return nil, ErrNotSealed
+	}
+	return data, nil
+}
@@ -0,0 +1,82 @@
+package crypt
+
+import (
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 7914 section 11 test vector.
+	got := hex.EncodeToString(pbkdf2([]byte("passwd"), []byte("salt"), 1, 64))
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if got != want {
+		t.Fatalf("pbkdf2 = %s, want %s", got, want)
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	raw, err := RawKey(make([]byte, keySize))
+	if err != nil {
+		t.Fatalf("RawKey: %v", err)
+	}
+	for name, key := range map[string]*Key{"passphrase": Passphrase("correct horse"), "raw": raw} {
+		t.Run(name, func(t *testing.T) {
+			sealed, err := Seal([]byte("secret"), key)
+			if err != nil {
+				t.Fatalf("Seal: %v", err)
+			}
+			if !IsSealed(sealed) {
+				t.Fatalf("sealed data missing header")
+			}
+			plain, err := Open(sealed, key)
+			if err != nil || string(plain) != "secret" {
+				t.Fatalf("Open = %q, %v", plain, err)
+			}
+			sealed[len(sealed)-1] ^= 1
+			if _, err := Open(sealed, key); !errors.Is(err, ErrTampered) {
+				t.Fatalf("Open(tampered) error = %v, want ErrTampered", err)
+			}
+		})
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	key := Passphrase("correct horse")
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.yaml")
+	sealed := filepath.Join(dir, "sealed.yaml.enc")
+	if err := WriteFile(plain, []byte("secret"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(sealed, []byte("secret"), key); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		key     *Key
+		wantErr error
+	}{
+		{"plaintext without key", plain, nil, nil},
+		{"sealed with key", sealed, key, nil},
+		{"plaintext with key", plain, key, ErrNotSealed},
+		{"sealed with wrong key", sealed, Passphrase("wrong"), ErrTampered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ReadFile(tt.path, tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadFile error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && string(data) != "secret" {
+				t.Fatalf("ReadFile = %q", data)
+			}
+		})
+	}
+	if _, err := ReadFile(sealed, nil); err == nil {
+		t.Fatalf("expected an error for sealed data without a key")
+	}
+}
@@ -0,0 +1,140 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+)
+
+// MigrationAccount renders CREATE USER and GRANT statements for a least-privilege account the
+// tool can connect as instead of root: read access to the mysql schema on a live source, and
+// on each target exactly what the precheck requires for the planned changes. Targets are not
+// contacted, so SYSTEM_USER is only included when a planned account is granted it, and with
+// the create-empty-schema policy CREATE is included for every database a grant names. When
+// accounts are backed up first, SELECT on the mysql schema lets the backup read them.
+func (r *Runner) MigrationAccount(ctx context.Context, account Identity) ([]byte, error) {
+	source := r.source()
+	users, err := r.loadSource(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	userRules, err := compileUserMap(r.UserMap)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- Migration account for mysql-user-migrate, planned from %s.\n", source.Describe())
+	b.WriteString("-- Replace <password> before running. Targets whose accounts hold SYSTEM_USER also need\n")
+	b.WriteString("-- SYSTEM_USER to replace them.\n")
+	create := fmt.Sprintf("CREATE USER IF NOT EXISTS %s IDENTIFIED BY '<password>';\n", account.Quoted())
+
+	if _, live := source.(*MySQLSource); live {
+		b.WriteString("\n-- source: read accounts, roles and SHOW GRANTS for other users\n")
+		b.WriteString(create)
+		fmt.Fprintf(&b, "%s;\n", Grant{Privileges: []string{"SELECT"}, Object: "`mysql`.*", Grantee: account})
+	}
+	for _, target := range r.Targets {
+		planned, err := r.prepareTarget(users, userRules, target)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", targetLabel(target), err)
+		}
+		reqs, _ := planRequirements(planned, nil, r.backsUp())
+		if r.missingObjects(target) == config.MissingObjectsCreateSchema {
+			reqs = append(reqs, schemaRequirements(planned)...)
+		}
+		fmt.Fprintf(&b, "\n-- target %s\n", targetLabel(target))
+		b.WriteString(create)
+		for _, g := range accountGrants(account, reqs) {
+			b.WriteString(g.String() + ";\n")
+		}
+	}
+	return []byte(b.String()), nil
+}
+
+// schemaRequirements returns CREATE on each database named by a database-level grant, which
+// the pre-flight checks create when it is missing.
+func schemaRequirements(users []targetUser) []requirement {
+	var out []requirement
+	for _, user := range users {
+		if user.Err != nil {
+			continue
+		}
+		for _, raw := range user.Grants {
+			g, err := ParseGrant(raw)
+			if err != nil || g.Kind != PrivilegeGrant {
+				continue
+			}
+			obj := g.ParseObject()
+			if obj.Database != "*" && obj.Table == "*" && obj.Routine == "" && !strings.Contains(obj.Database, "%") {
+				out = append(out, requirement{kind: PrivilegeGrant, priv: "CREATE", obj: obj})
+			}
+		}
+	}
+	return out
+}
+
+// accountGrants turns requirements into grants for account, one per object. Role grants need
+// ROLE_ADMIN because the roles may not exist until the migration creates them.
+func accountGrants(account Identity, reqs []requirement) []Grant {
+	type objectGrant struct {
+		obj         GrantObject
+		privs       map[string]bool
+		grantOption bool
+	}
+	objects := make(map[string]*objectGrant)
+	var proxies []Grant
+	add := func(priv string, obj GrantObject) {
+		key := canonicalObject(obj)
+		og, ok := objects[key]
+		if !ok {
+			og = &objectGrant{obj: obj, privs: make(map[string]bool)}
+			objects[key] = og
+		}
+		if priv == "GRANT OPTION" {
+			og.grantOption = true
+		} else {
+			og.privs[priv] = true
+		}
+	}
+	for _, q := range reqs {
+		switch q.kind {
+		case RoleGrant:
+			add("ROLE_ADMIN", globalObject)
+		case ProxyGrant:
+			proxies = append(proxies, Grant{Kind: ProxyGrant, Proxied: q.account, Grantee: account, GrantOption: true})
+		default:
+			add(q.priv, q.obj)
+		}
+	}
+
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ri, rj := objectRank(objects[keys[i]].obj), objectRank(objects[keys[j]].obj)
+		if ri != rj {
+			return ri < rj
+		}
+		return keys[i] < keys[j]
+	})
+	out := make([]Grant, 0, len(keys)+len(proxies))
+	for _, key := range keys {
+		og := objects[key]
+		privs := make([]string, 0, len(og.privs))
+		for p := range og.privs {
+			privs = append(privs, p)
+		}
+		if len(privs) == 0 {
+			privs = append(privs, "USAGE")
+		}
+		sort.Strings(privs)
+		out = append(out, Grant{Privileges: privs, Object: key, Grantee: account, GrantOption: og.grantOption})
+	}
+	sort.Slice(proxies, func(i, j int) bool { return proxies[i].Proxied.String() < proxies[j].Proxied.String() })
+	return append(out, proxies...)
+}
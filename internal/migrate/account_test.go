@@ -0,0 +1,86 @@
+package migrate
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+)
+
+func TestMigrationAccount(t *testing.T) {
+	dir := writeSpecs(t, map[string]string{
+		"reader.yaml": "user: reader\nrole: true\nprivileges:\n  - on: shop.*\n    privileges: [SELECT]\n",
+		"app.yaml":    "user: app\nprivileges:\n  - on: shop.orders\n    privileges: [SELECT, INSERT]\n  - on: '*.*'\n    privileges: [PROCESS]\nroles: [reader]\n",
+	})
+	r := &Runner{
+		Source:  &DirectorySource{Path: dir},
+		Targets: []config.Target{{Name: "prod"}, {Name: "replica"}},
+		PrivilegeRules: []config.PrivilegeRule{
+			{Targets: []string{"replica"}, Drop: []string{"INSERT", "ADMIN"}},
+		},
+	}
+	out, err := r.MigrationAccount(context.Background(), Identity{User: "mig", Host: "10.%"})
+	if err != nil {
+		t.Fatalf("MigrationAccount: %v", err)
+	}
+	got := string(out)
+	prod := "-- target prod\n" +
+		"CREATE USER IF NOT EXISTS 'mig'@'10.%' IDENTIFIED BY '<password>';\n" +
+		"GRANT CREATE USER, PROCESS, ROLE_ADMIN ON *.* TO 'mig'@'10.%' WITH GRANT OPTION;\n" +
+		"GRANT SELECT ON `shop`.* TO 'mig'@'10.%' WITH GRANT OPTION;\n" +
+		"GRANT SELECT ON `mysql`.`user` TO 'mig'@'10.%';\n" +
+		"GRANT INSERT, SELECT ON `shop`.`orders` TO 'mig'@'10.%' WITH GRANT OPTION;\n"
+	replica := "-- target replica\n" +
+		"CREATE USER IF NOT EXISTS 'mig'@'10.%' IDENTIFIED BY '<password>';\n" +
+		"GRANT CREATE USER, ROLE_ADMIN ON *.* TO 'mig'@'10.%';\n" +
+		"GRANT SELECT ON `shop`.* TO 'mig'@'10.%' WITH GRANT OPTION;\n" +
+		"GRANT SELECT ON `mysql`.`user` TO 'mig'@'10.%';\n" +
+		"GRANT SELECT ON `shop`.`orders` TO 'mig'@'10.%' WITH GRANT OPTION;\n"
+	for _, want := range []string{prod, replica} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing\n%s\ngot\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "-- source") || strings.Contains(got, "`mysql`.*") {
+		t.Errorf("desired-state source needs no source grants:\n%s", got)
+	}
+
+	// Backups read the accounts with SHOW CREATE USER and SHOW GRANTS.
+	r.RollbackOnFail = true
+	out, err = r.MigrationAccount(context.Background(), Identity{User: "mig", Host: "10.%"})
+	if err != nil {
+		t.Fatalf("MigrationAccount: %v", err)
+	}
+	if want := "GRANT SELECT ON `mysql`.* TO 'mig'@'10.%';\n"; strings.Count(string(out), want) != 2 {
+		t.Errorf("each target should grant %q:\n%s", want, out)
+	}
+}
+
+func TestMigrationAccountCreateSchema(t *testing.T) {
+	dir := writeSpecs(t, map[string]string{
+		"app.yaml": "user: app\nprivileges:\n  - on: shop.*\n    privileges: [SELECT]\n  - on: shop.orders\n    privileges: [INSERT]\n",
+	})
+	r := &Runner{
+		Source:  &DirectorySource{Path: dir},
+		Targets: []config.Target{{Name: "prod"}, {Name: "staging", MissingObjects: config.MissingObjectsCreateSchema}},
+	}
+	out, err := r.MigrationAccount(context.Background(), Identity{User: "mig", Host: "%"})
+	if err != nil {
+		t.Fatalf("MigrationAccount: %v", err)
+	}
+	got := string(out)
+	prod, staging, _ := strings.Cut(got[strings.Index(got, "-- target prod"):], "-- target staging")
+	if want := "GRANT SELECT ON `shop`.* TO 'mig'@'%' WITH GRANT OPTION;\n"; !strings.Contains(prod, want) {
+		t.Errorf("prod missing %q:\n%s", want, prod)
+	}
+	// Only the database is created; the table grant needs no CREATE.
+	for _, want := range []string{
+		"GRANT CREATE, SELECT ON `shop`.* TO 'mig'@'%' WITH GRANT OPTION;\n",
+		"GRANT INSERT ON `shop`.`orders` TO 'mig'@'%' WITH GRANT OPTION;\n",
+	} {
+		if !strings.Contains(staging, want) {
+			t.Errorf("staging missing %q:\n%s", want, staging)
+		}
+	}
+}
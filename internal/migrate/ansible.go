@@ -0,0 +1,213 @@
+package migrate
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AnsibleHashVar is the variable holding password hashes in HashesVariables mode, keyed by
+// user@host.
+const AnsibleHashVar = "mysql_user_hashes"
+
+type ansibleTask struct {
+	Name  string       `yaml:"name"`
+	User  *ansibleUser `yaml:"community.mysql.mysql_user,omitempty"`
+	Role  *ansibleRole `yaml:"community.mysql.mysql_role,omitempty"`
+	NoLog bool         `yaml:"no_log,omitempty"`
+}
+
+type ansibleUser struct {
+	Name             string         `yaml:"name"`
+	Host             string         `yaml:"host"`
+	Password         string         `yaml:"password,omitempty"`
+	Encrypted        bool           `yaml:"encrypted,omitempty"`
+	Plugin           string         `yaml:"plugin,omitempty"`
+	PluginHashString string         `yaml:"plugin_hash_string,omitempty"`
+	Priv             string         `yaml:"priv"`
+	ResourceLimits   map[string]int `yaml:"resource_limits,omitempty"`
+	State            string         `yaml:"state"`
+}
+
+type ansibleRole struct {
+	Name          string   `yaml:"name"`
+	Priv          string   `yaml:"priv"`
+	Members       []string `yaml:"members,omitempty"`
+	AppendMembers bool     `yaml:"append_members,omitempty"`
+	State         string   `yaml:"state"`
+}
+
+// Ansible renders the snapshot as community.mysql tasks: mysql_user per account with priv
+// built from its grants, and mysql_role per role with its members. With HashesVariables the
+// hashes are read from AnsibleHashVar, returned separately as a vars file. Binary hashes
+// (caching_sha2_password) cannot be passed through YAML and are left out with a comment.
+// Partial revokes have no priv syntax; they are listed in a comment and returned as warnings.
+func (s *Snapshot) Ansible(hashes HashMode) (tasks, vars []byte, warnings []string, err error) {
+	users, err := s.Users()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	members := make(map[string][]string)
+	for _, u := range users {
+		for _, raw := range u.Grants {
+			g, err := parseStatement(raw)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("%s: %w", u.RawIdentity, err)
+			}
+			if g.Revoke {
+				continue
+			}
+			for _, role := range g.Roles {
+				members[role.String()] = append(members[role.String()], u.RawIdentity)
+			}
+		}
+	}
+
+	var (
+		doc      yaml.Node
+		hashVars = make(map[string]string)
+		seq      = &yaml.Node{Kind: yaml.SequenceNode}
+	)
+	doc.Kind = yaml.DocumentNode
+	doc.HeadComment = fmt.Sprintf("Generated by mysql-user-migrate from %s.", s.Source)
+	doc.Content = []*yaml.Node{seq}
+
+	for _, u := range users {
+		priv, revokes, err := ansiblePriv(u.Grants)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %w", u.RawIdentity, err)
+		}
+		task := ansibleTask{}
+		var comments []string
+		for _, raw := range revokes {
+			comments = append(comments, "Not representable in priv: "+raw)
+			warnings = append(warnings, fmt.Sprintf("%s: not representable in priv: %s", u.RawIdentity, raw))
+		}
+		if u.Role && u.Host == "%" {
+			task.Name = "MySQL role " + u.User
+			task.Role = &ansibleRole{Name: u.User, Priv: priv, Members: members[u.RawIdentity], AppendMembers: true, State: "present"}
+		} else {
+			task.Name = "MySQL user " + u.RawIdentity
+			user := &ansibleUser{Name: u.User, Host: u.Host, Priv: priv, ResourceLimits: ansibleLimits(u.Attributes), State: "present"}
+			if u.Plugin != "" && u.Plugin != "mysql_native_password" {
+				user.Plugin = u.Plugin
+			}
+			switch {
+			case u.AuthString == "" || hashes == HashesOmit:
+			case !isPrintable(u.AuthString):
+				comments = append(comments, "Binary "+u.Plugin+" hash omitted; set the password separately.")
+			default:
+				value := u.AuthString
+				if hashes == HashesVariables {
+					hashVars[u.RawIdentity] = value
+					value = fmt.Sprintf("{{ %s[%q] }}", AnsibleHashVar, u.RawIdentity)
+				}
+				if user.Plugin == "" {
+					user.Password, user.Encrypted = value, true
+				} else {
+					user.PluginHashString = value
+				}
+				task.NoLog = true
+			}
+			task.User = user
+		}
+
+		var node yaml.Node
+		if err := node.Encode(task); err != nil {
+			return nil, nil, nil, err
+		}
+		node.HeadComment = strings.Join(comments, "\n")
+		seq.Content = append(seq.Content, &node)
+	}
+
+	tasks, err = encodeYAML(&doc)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(hashVars) > 0 {
+		vars, err = encodeYAML(map[string]map[string]string{AnsibleHashVar: hashVars})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return tasks, vars, warnings, nil
+}
+
+// ansiblePriv builds a priv string such as "*.*:USAGE/shop.*:SELECT,INSERT,GRANT". Partial
+// revokes cannot be expressed in it and are returned unchanged.
+func ansiblePriv(grants []string) (priv string, revokes []string, err error) {
+	var parts []string
+	for _, raw := range grants {
+		g, err := parseStatement(raw)
+		if err != nil {
+			return "", nil, err
+		}
+		if g.Revoke {
+			revokes = append(revokes, raw)
+			continue
+		}
+		if g.Kind != PrivilegeGrant {
+			continue // roles are granted through mysql_role members; proxies are not supported
+		}
+		obj := g.ParseObject()
+		object := ansibleName(obj.Database) + "." + ansibleName(obj.Table)
+		if obj.Routine != "" {
+			object = obj.Routine + " " + object
+		}
+		privs := make([]string, 0, len(g.Privileges)+1)
+		for _, p := range g.Privileges {
+			privs = append(privs, strings.ReplaceAll(p, "`", ""))
+		}
+		if g.GrantOption {
+			privs = append(privs, "GRANT")
+		}
+		parts = append(parts, object+":"+strings.Join(privs, ","))
+	}
+	if len(parts) == 0 {
+		return "*.*:USAGE", revokes, nil
+	}
+	return strings.Join(parts, "/"), revokes, nil
+}
+
+// ansibleName backquotes names the module would otherwise split or misread.
+func ansibleName(name string) string {
+	if name == "*" {
+		return name
+	}
+	for i := 0; i < len(name); i++ {
+		if !isWordByte(name[i]) {
+			return quoteName(name)
+		}
+	}
+	return name
+}
+
+// ansibleLimits maps resource limit attributes to the module's resource_limits.
+func ansibleLimits(attrs map[string]string) map[string]int {
+	limits := make(map[string]int)
+	for option, column := range resourceLimits {
+		var n int
+		if _, err := fmt.Sscan(attrs[column], &n); err == nil && n > 0 {
+			limits[option] = n
+		}
+	}
+	if len(limits) == 0 {
+		return nil
+	}
+	return limits
+}
+
+func encodeYAML(v any) ([]byte, error) {
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
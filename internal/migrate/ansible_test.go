@@ -0,0 +1,71 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSnapshotAnsible(t *testing.T) {
+	snap := &Snapshot{Version: SnapshotVersion, Source: "src"}
+	for _, u := range []UserRecord{
+		{User: "reader", Host: "%", Role: true, Grants: []string{
+			"GRANT USAGE ON *.* TO `reader`@`%`",
+			"GRANT SELECT ON `shop`.* TO `reader`@`%`",
+		}},
+		{User: "app", Host: "10.0.%", Plugin: "mysql_native_password", AuthString: "*ABCD", Attributes: map[string]string{"max_user_connections": "20"}, Grants: []string{
+			"GRANT USAGE ON *.* TO `app`@`10.0.%`",
+			"GRANT SELECT (`id`, `total`), DELETE ON `my-db`.`orders` TO `app`@`10.0.%` WITH GRANT OPTION",
+			"GRANT EXECUTE ON PROCEDURE `shop`.`refund` TO `app`@`10.0.%`",
+			"GRANT `reader`@`%` TO `app`@`10.0.%`",
+			"REVOKE DELETE ON `shop`.* FROM `app`@`10.0.%`",
+		}},
+		{User: "svc", Host: "%", Plugin: "caching_sha2_password", AuthString: "$A$005$\x01\x02"},
+	} {
+		snap.Accounts = append(snap.Accounts, snapshotAccount(u))
+	}
+
+	out, vars, warnings, err := snap.Ansible(HashesVariables)
+	if err != nil {
+		t.Fatalf("Ansible: %v", err)
+	}
+	var tasks []map[string]any
+	if err := yaml.Unmarshal(out, &tasks); err != nil {
+		t.Fatalf("tasks are not valid YAML: %v\n%s", err, out)
+	}
+	if len(tasks) != 3 {
+		t.Fatalf("got %d tasks:\n%s", len(tasks), out)
+	}
+
+	role := tasks[0]["community.mysql.mysql_role"].(map[string]any)
+	if role["priv"] != "*.*:USAGE/shop.*:SELECT" || role["members"].([]any)[0] != "app@10.0.%" {
+		t.Fatalf("role task = %v", role)
+	}
+
+	app := tasks[1]["community.mysql.mysql_user"].(map[string]any)
+	wantPriv := "*.*:USAGE/`my-db`.orders:SELECT (id, total),DELETE,GRANT/PROCEDURE shop.refund:EXECUTE"
+	if app["priv"] != wantPriv {
+		t.Fatalf("priv = %q, want %q", app["priv"], wantPriv)
+	}
+	if app["password"] != `{{ mysql_user_hashes["app@10.0.%"] }}` || app["encrypted"] != true || tasks[1]["no_log"] != true {
+		t.Fatalf("app task = %v", tasks[1])
+	}
+	if app["resource_limits"].(map[string]any)["MAX_USER_CONNECTIONS"] != 20 {
+		t.Fatalf("resource_limits = %v", app["resource_limits"])
+	}
+
+	svc := tasks[2]["community.mysql.mysql_user"].(map[string]any)
+	if _, ok := svc["plugin_hash_string"]; ok || !strings.Contains(string(out), "# Binary caching_sha2_password hash omitted") {
+		t.Fatalf("binary hash should be omitted with a comment:\n%s", out)
+	}
+	if !strings.Contains(string(out), "# Not representable in priv: REVOKE DELETE ON `shop`.* FROM `app`@`10.0.%`") {
+		t.Fatalf("partial revoke should be listed in a comment:\n%s", out)
+	}
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "app@10.0.%: not representable in priv: REVOKE DELETE") {
+		t.Fatalf("warnings = %q", warnings)
+	}
+	if string(vars) != "mysql_user_hashes:\n  app@10.0.%: '*ABCD'\n" {
+		t.Fatalf("vars = %q", vars)
+	}
+}
@@ -0,0 +1,127 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+	"github.com/raojinlin/mysql-user-migrate/internal/credential"
+)
+
+// passwordPlugins accept a cleartext password in IDENTIFIED WITH ... BY.
+var passwordPlugins = map[string]bool{
+	"mysql_native_password": true,
+	"caching_sha2_password": true,
+	"sha256_password":       true,
+}
+
+// passwordAuth sets a cleartext password that the target hashes with its own plugin.
+type passwordAuth struct {
+	Plugin   string
+	Password string
+}
+
+func (p passwordAuth) clause() string {
+	if p.Plugin == "" {
+		return fmt.Sprintf("IDENTIFIED BY '%s'", escape(p.Password))
+	}
+	return fmt.Sprintf("IDENTIFIED WITH '%s' BY '%s'", escape(p.Plugin), escape(p.Password))
+}
+
+// authMode resolves the auth mode for a target.
+func (r *Runner) authMode(target config.Target) string {
+	if target.AuthMode != "" {
+		return target.AuthMode
+	}
+	if r.Credentials.Mode != "" {
+		return r.Credentials.Mode
+	}
+	return config.AuthModeCopy
+}
+
+// passwordPlugin picks the plugin used when a password is set in cleartext.
+func passwordPlugin(target config.Target, user UserRecord) (string, error) {
+	if target.AuthPlugin != "" {
+		return target.AuthPlugin, nil
+	}
+	if user.Plugin == "" || passwordPlugins[user.Plugin] {
+		return user.Plugin, nil
+	}
+	return "", fmt.Errorf("plugin %s does not take passwords; set auth_plugin on the target", user.Plugin)
+}
+
+// rotatePassword generates a password for user and stores it in the sink before it is used;
+// sinks persist on Put, so a password is never set on a target without being recorded.
+func (r *Runner) rotatePassword(ctx context.Context, target config.Target, user UserRecord) (*passwordAuth, error) {
+	if r.Sink == nil {
+		return nil, errors.New("no credential sink configured")
+	}
+	plugin, err := passwordPlugin(target, user)
+	if err != nil {
+		return nil, err
+	}
+	password, err := credential.Generate(r.Credentials.Policy)
+	if err != nil {
+		return nil, err
+	}
+	cred := credential.Credential{
+		Target:   targetLabel(target),
+		User:     user.User,
+		Host:     user.Host,
+		Password: password,
+	}
+	if err := r.Sink.Put(ctx, cred); err != nil {
+		return nil, fmt.Errorf("store credential: %w", err)
+	}
+	return &passwordAuth{Plugin: plugin, Password: password}, nil
+}
+
+// targetLabel names a target in reports and credentials.
+func targetLabel(target config.Target) string {
+	if target.Name != "" {
+		return target.Name
+	}
+	return MaskDSN(target.DSN)
+}
+
+// secretPassword looks up the password for user: by its SecretRef when it has one, otherwise
+// trying the target account before the source account.
+func (r *Runner) secretPassword(ctx context.Context, target config.Target, user targetUser) (*passwordAuth, string, error) {
+	if r.Secrets == nil {
+		return nil, "", errors.New("no secrets source configured")
+	}
+	label := targetLabel(target)
+	if user.SecretRef != "" {
+		password, source, err := r.Secrets.LookupRef(ctx, label, user.SecretRef, user.User, user.Host)
+		if err != nil {
+			return nil, "", err
+		}
+		if source == "" {
+			return nil, "", fmt.Errorf("no secret found for %q", user.SecretRef)
+		}
+		plugin, err := passwordPlugin(target, user.UserRecord)
+		if err != nil {
+			return nil, "", err
+		}
+		return &passwordAuth{Plugin: plugin, Password: password}, source, nil
+	}
+	password, source, err := r.Secrets.Lookup(ctx, label, user.User, user.Host)
+	if err == nil && source == "" && user.RawIdentity != user.User+"@"+user.Host {
+		src := user.RawIdentity
+		at := strings.LastIndex(src, "@")
+		password, source, err = r.Secrets.Lookup(ctx, label, src[:at], src[at+1:])
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if source == "" {
+		return nil, "", errors.New("no secret found")
+	}
+	plugin, err := passwordPlugin(target, user.UserRecord)
+	if err != nil {
+		return nil, "", err
+	}
+	return &passwordAuth{Plugin: plugin, Password: password}, source, nil
+}
@@ -0,0 +1,178 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+	"github.com/raojinlin/mysql-user-migrate/internal/crypt"
+)
+
+// querier reads from a target; *sql.DB and *sql.Conn implement it.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// accountBackup is an account's definition on a target before the run changed it.
+type accountBackup struct {
+	id      Identity
+	existed bool
+	create  string   // SHOW CREATE USER, or a bare CREATE USER where the server lacks it
+	grants  []string // SHOW GRANTS
+}
+
+// restore returns the statements that put the account back: accounts the run created are
+// dropped, the others are dropped and recreated as they were.
+func (a *accountBackup) restore() []string {
+	stmts := []string{"DROP USER IF EXISTS " + a.id.Quoted()}
+	if !a.existed {
+		return stmts
+	}
+	stmts = append(stmts, a.create)
+	return append(stmts, a.grants...)
+}
+
+// targetBackup holds the accounts of a target that a run is about to change.
+type targetBackup struct {
+	takenAt  time.Time
+	accounts []*accountBackup
+}
+
+// backupTarget captures the accounts of users that will be applied and links each user to
+// its backup. It reads through one connection so the session setting applies to every read;
+// each read goes through rt.
+func backupTarget(ctx context.Context, db *sql.DB, rt *retrier, users []targetUser) (*targetBackup, error) {
+	var conn *sql.Conn
+	err := rt.do(ctx, func(ctx context.Context) (err error) {
+		conn, err = db.Conn(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// MySQL 8.0.17 and later print binary hashes as hex so the output can be replayed; older
+	// servers do not know the variable.
+	rt.attempt(ctx, func(ctx context.Context) error {
+		_, err := conn.ExecContext(ctx, "SET SESSION print_identified_with_as_hex = ON")
+		return err
+	})
+
+	b := &targetBackup{takenAt: time.Now()}
+	for i := range users {
+		user := &users[i]
+		if user.Err != nil {
+			continue
+		}
+		id := Identity{User: user.User, Host: user.Host}
+		a, err := backupAccount(ctx, conn, rt, id)
+		if err != nil {
+			return nil, fmt.Errorf("back up %s: %w", id, err)
+		}
+		user.Backup = a
+		b.accounts = append(b.accounts, a)
+	}
+	return b, nil
+}
+
+func backupAccount(ctx context.Context, q querier, rt *retrier, id Identity) (*accountBackup, error) {
+	a := &accountBackup{id: id}
+	err := rt.do(ctx, func(ctx context.Context) (err error) {
+		a.existed, err = userExists(ctx, q, id.User, id.Host)
+		return err
+	})
+	if err != nil || !a.existed {
+		return a, err
+	}
+	create, err := rt.queryStrings(ctx, q, "SHOW CREATE USER "+id.Quoted())
+	switch {
+	case mysqlErrorNumber(err) == 1064:
+		// MySQL 5.6 has no SHOW CREATE USER; its SHOW GRANTS carries the password hash.
+		a.create = "CREATE USER " + id.Quoted()
+	case err != nil:
+		return nil, err
+	case len(create) != 1:
+		return nil, fmt.Errorf("SHOW CREATE USER returned %d rows", len(create))
+	case !isPrintable(create[0]):
+		return nil, fmt.Errorf("SHOW CREATE USER returned a binary password hash; MySQL 8.0.17 or later prints it as hex")
+	default:
+		a.create = create[0]
+	}
+	if a.grants, err = rt.queryStrings(ctx, q, "SHOW GRANTS FOR "+id.Quoted()); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// script orders the restore statements of every account: all drops, then creates, then
+// grants, so roles exist again before they are granted.
+func (b *targetBackup) script() *sqlScript {
+	s := &sqlScript{}
+	for _, a := range b.accounts {
+		for _, stmt := range a.restore() {
+			s.ExecContext(context.Background(), stmt)
+		}
+	}
+	return s
+}
+
+func (b *targetBackup) render(target, dsn string) []byte {
+	created := 0
+	for _, a := range b.accounts {
+		if !a.existed {
+			created++
+		}
+	}
+	s := b.script()
+	var out strings.Builder
+	fmt.Fprintf(&out, "-- mysql-user-migrate undo script\n")
+	fmt.Fprintf(&out, "-- target: %s (%s)\n", target, MaskDSN(dsn))
+	fmt.Fprintf(&out, "-- taken: %s, before any change\n", b.takenAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&out, "-- accounts: %d (%d did not exist and are dropped)\n", len(b.accounts), created)
+	s.writeBody(&out)
+	return []byte(out.String())
+}
+
+// writeUndo writes the target's undo script to BackupDir, sealed when a key is configured.
+// The file name carries the time so a later run does not replace an earlier backup.
+func (r *Runner) writeUndo(b *targetBackup, target config.Target) (string, error) {
+	label := targetLabel(target)
+	if err := os.MkdirAll(r.BackupDir, 0o700); err != nil {
+		return "", fmt.Errorf("write undo script: %w", err)
+	}
+	name := fmt.Sprintf("%s-%s.undo.sql", fileSafe(label), b.takenAt.UTC().Format("20060102T150405Z"))
+	if r.Key != nil {
+		name += ".enc"
+	}
+	path := filepath.Join(r.BackupDir, name)
+	if err := crypt.WriteFile(path, b.render(label, target.DSN), r.Key); err != nil {
+		return "", fmt.Errorf("write undo script: %w", err)
+	}
+	return path, nil
+}
+
+// rollbackTarget runs the undo statements after a failed target run. It stops at the first
+// failure; the undo script, when written, can be finished by hand.
+func rollbackTarget(ctx context.Context, exec execer, b *targetBackup, result *TargetReport) {
+	stmts := b.script().statements()
+	for i, stmt := range stmts {
+		if _, err := exec.ExecContext(ctx, stmt); err != nil {
+			result.Rollback = fmt.Sprintf("failed after %d of %d statements: %v", i, len(stmts), err)
+			return
+		}
+	}
+	result.Rollback = fmt.Sprintf("restored %d accounts", len(b.accounts))
+	for i := range result.Users {
+		u := &result.Users[i]
+		if !u.Resumed && (u.Status == "applied" || u.Status == "partial") {
+			u.Status = "rolled-back"
+		}
+	}
+	result.recount()
+}
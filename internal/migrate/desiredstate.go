@@ -0,0 +1,263 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AccountSpec is one desired-state file: an account (or role) with its hosts, password and
+// privileges.
+type AccountSpec struct {
+	User       string          `yaml:"user"`
+	Role       bool            `yaml:"role"`
+	Hosts      []string        `yaml:"hosts"` // default ["%"]
+	Plugin     string          `yaml:"plugin"`
+	Password   PasswordSpec    `yaml:"password"`
+	Privileges []PrivilegeSpec `yaml:"privileges"`
+	Roles      []string        `yaml:"roles"` // role or role@host, defined in the same directory
+}
+
+// PasswordSpec sets the password from a hash, or from a secret resolved at apply time through
+// credentials.secrets. Roles and passwordless accounts leave it empty.
+type PasswordSpec struct {
+	Hash    string `yaml:"hash"`
+	HashHex string `yaml:"hash_hex"`
+	Secret  string `yaml:"secret"`
+}
+
+// PrivilegeSpec grants privileges on an object: *.*, db.*, db.table, or PROCEDURE db.proc.
+type PrivilegeSpec struct {
+	On          string   `yaml:"on"`
+	Privileges  []string `yaml:"privileges"`
+	GrantOption bool     `yaml:"grant_option"`
+}
+
+// DirectorySource reads desired-state account files (*.yaml, *.yml) from a directory tree,
+// typically a reviewed git repository.
+type DirectorySource struct {
+	Path string
+}
+
+// Load reads and validates every file and returns the matching accounts. All problems are
+// reported together.
+func (s *DirectorySource) Load(_ context.Context, match func(user, host string) bool) ([]UserRecord, error) {
+	specs, err := ReadDesiredState(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	users, err := desiredUsers(specs)
+	if err != nil {
+		return nil, err
+	}
+	return filterUsers(users, match), nil
+}
+
+// Describe names the directory.
+func (s *DirectorySource) Describe() string {
+	return "desired state " + s.Path
+}
+
+// ServerVersion is unknown for desired state.
+func (s *DirectorySource) ServerVersion() string {
+	return ""
+}
+
+// ReadDesiredState parses every account file under dir, rejecting unknown fields. Specs are
+// keyed by their path relative to dir.
+func ReadDesiredState(dir string) (map[string]AccountSpec, error) {
+	specs := make(map[string]AccountSpec)
+	var problems []error
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isYAMLPath(path) {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		spec, err := readAccountSpec(path)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", rel, err))
+			return nil
+		}
+		specs[rel] = spec
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read desired state: %w", err)
+	}
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no account files (*.yaml, *.yml) in %s", dir)
+	}
+	return specs, nil
+}
+
+func readAccountSpec(path string) (AccountSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return AccountSpec{}, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var spec AccountSpec
+	if err := dec.Decode(&spec); err != nil {
+		return AccountSpec{}, err
+	}
+	var extra AccountSpec
+	if err := dec.Decode(&extra); !errors.Is(err, io.EOF) {
+		return AccountSpec{}, errors.New("one account per file")
+	}
+	return spec, nil
+}
+
+var privilegeNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z_ ]*( \(\s*[^()]+\))?$`)
+
+// validate checks a spec on its own; references between files are checked by desiredUsers.
+func (spec AccountSpec) validate() []error {
+	var problems []error
+	if spec.User == "" {
+		problems = append(problems, errors.New("user is required"))
+	}
+	set := 0
+	for _, v := range []string{spec.Password.Hash, spec.Password.HashHex, spec.Password.Secret} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		problems = append(problems, errors.New("password: set only one of hash, hash_hex and secret"))
+	}
+	if spec.Password.HashHex != "" {
+		if _, err := hex.DecodeString(spec.Password.HashHex); err != nil {
+			problems = append(problems, fmt.Errorf("password.hash_hex: %w", err))
+		}
+	}
+	if spec.Role && set > 0 {
+		problems = append(problems, errors.New("roles cannot have a password"))
+	}
+	for i, p := range spec.Privileges {
+		if !strings.Contains(p.On, ".") {
+			problems = append(problems, fmt.Errorf("privileges[%d]: on %q must be *.*, db.*, db.table or PROCEDURE db.name", i, p.On))
+		}
+		if len(p.Privileges) == 0 {
+			problems = append(problems, fmt.Errorf("privileges[%d]: no privileges listed", i))
+		}
+		for _, name := range p.Privileges {
+			if !privilegeNameRe.MatchString(strings.TrimSpace(name)) {
+				problems = append(problems, fmt.Errorf("privileges[%d]: invalid privilege %q", i, name))
+			}
+		}
+	}
+	return problems
+}
+
+// desiredUsers validates the specs and expands them to one record per host, roles first.
+func desiredUsers(specs map[string]AccountSpec) ([]UserRecord, error) {
+	var (
+		problems []error
+		defined  = make(map[Identity]string)
+		users    []UserRecord
+	)
+	files := sortedKeys(specs)
+	// Roles come first, so the runner creates them before granting them to other accounts.
+	sort.SliceStable(files, func(i, j int) bool { return specs[files[i]].Role && !specs[files[j]].Role })
+	for _, file := range files {
+		spec := specs[file]
+		for _, err := range spec.validate() {
+			problems = append(problems, fmt.Errorf("%s: %w", file, err))
+		}
+		for _, host := range specHosts(spec) {
+			id := Identity{User: spec.User, Host: host}
+			if prev, ok := defined[id]; ok {
+				problems = append(problems, fmt.Errorf("%s: %s is already defined in %s", file, id, prev))
+			}
+			defined[id] = file
+		}
+	}
+
+	for _, file := range files {
+		spec := specs[file]
+		roles := make([]Identity, 0, len(spec.Roles))
+		for _, name := range spec.Roles {
+			role, rest, err := readIdentity(name)
+			if err != nil || rest != "" {
+				problems = append(problems, fmt.Errorf("%s: invalid role %q", file, name))
+				continue
+			}
+			if roleFile, ok := defined[role]; !ok || !specs[roleFile].Role {
+				problems = append(problems, fmt.Errorf("%s: role %s is not defined as a role", file, role))
+			}
+			roles = append(roles, role)
+		}
+
+		auth := spec.Password.Hash
+		if spec.Password.HashHex != "" {
+			b, _ := hex.DecodeString(spec.Password.HashHex)
+			auth = string(b)
+		}
+		for _, host := range specHosts(spec) {
+			id := Identity{User: spec.User, Host: host}
+			grants := []string{Grant{Privileges: []string{"USAGE"}, Object: "*.*", Grantee: id}.String()}
+			for _, p := range spec.Privileges {
+				privs := make([]string, len(p.Privileges))
+				for i, name := range p.Privileges {
+					privs[i] = normalizePrivilege(name)
+				}
+				grants = append(grants, Grant{Privileges: privs, Object: specObject(p.On), Grantee: id, GrantOption: p.GrantOption}.String())
+			}
+			if len(roles) > 0 {
+				grants = append(grants, Grant{Kind: RoleGrant, Roles: roles, Grantee: id}.String())
+			}
+			users = append(users, UserRecord{
+				User:        id.User,
+				Host:        id.Host,
+				Plugin:      spec.Plugin,
+				AuthString:  auth,
+				Grants:      grants,
+				RawIdentity: id.String(),
+				Role:        spec.Role,
+				SecretRef:   spec.Password.Secret,
+			})
+		}
+	}
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+	return users, nil
+}
+
+func specHosts(spec AccountSpec) []string {
+	if len(spec.Hosts) == 0 {
+		return []string{"%"}
+	}
+	return spec.Hosts
+}
+
+// specObject quotes the names in an ON clause: shop.* becomes `shop`.*.
+func specObject(on string) string {
+	return canonicalObject(Grant{Object: on}.ParseObject())
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
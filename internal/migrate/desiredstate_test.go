@@ -0,0 +1,116 @@
+package migrate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeSpecs(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestDirectorySource(t *testing.T) {
+	dir := writeSpecs(t, map[string]string{
+		// The role sorts after its grantee but is still loaded first.
+		"users/reader.yaml": "user: reader\nrole: true\nprivileges:\n  - on: shop.*\n    privileges: [select]\n",
+		"users/app.yml": `user: app
+hosts: ["10.0.%", localhost]
+plugin: caching_sha2_password
+password:
+  secret: app-prod
+privileges:
+  - on: shop.orders
+    privileges: [SELECT, insert]
+    grant_option: true
+  - on: PROCEDURE shop.refund
+    privileges: [EXECUTE]
+roles: [reader]
+`,
+		"README.md": "not an account",
+	})
+
+	src := &DirectorySource{Path: dir}
+	users, err := src.Load(context.Background(), func(user, host string) bool { return true })
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(users) != 3 {
+		t.Fatalf("loaded %d accounts: %+v", len(users), users)
+	}
+	reader := users[0]
+	if !reader.Role || !reflect.DeepEqual(reader.Grants, []string{
+		"GRANT USAGE ON *.* TO 'reader'@'%'",
+		"GRANT SELECT ON `shop`.* TO 'reader'@'%'",
+	}) {
+		t.Fatalf("reader = %+v", reader)
+	}
+	app := users[1]
+	wantGrants := []string{
+		"GRANT USAGE ON *.* TO 'app'@'10.0.%'",
+		"GRANT SELECT, INSERT ON `shop`.`orders` TO 'app'@'10.0.%' WITH GRANT OPTION",
+		"GRANT EXECUTE ON PROCEDURE `shop`.`refund` TO 'app'@'10.0.%'",
+		"GRANT 'reader'@'%' TO 'app'@'10.0.%'",
+	}
+	if app.RawIdentity != "app@10.0.%" || app.SecretRef != "app-prod" || !reflect.DeepEqual(app.Grants, wantGrants) {
+		t.Fatalf("app = %+v", app)
+	}
+	if users[2].RawIdentity != "app@localhost" {
+		t.Fatalf("second host = %s", users[2].RawIdentity)
+	}
+}
+
+func TestDirectorySourceValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{
+			name:  "unknown field",
+			files: map[string]string{"a.yaml": "user: a\nhost: '%'\n"},
+			want:  []string{"a.yaml", "field host not found"},
+		},
+		{
+			name: "references and duplicates",
+			files: map[string]string{
+				"a.yaml": "user: a\nroles: [missing]\npassword: {hash: '*A', secret: a}\n",
+				"b.yaml": "user: a\nprivileges:\n  - on: shop\n    privileges: [SELECT]\n",
+			},
+			want: []string{
+				"a.yaml: role missing@% is not defined as a role",
+				"a.yaml: password: set only one of hash, hash_hex and secret",
+				"b.yaml: a@% is already defined in a.yaml",
+				`b.yaml: privileges[0]: on "shop"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &DirectorySource{Path: writeSpecs(t, tt.files)}
+			_, err := src.Load(context.Background(), func(user, host string) bool { return true })
+			if err == nil {
+				t.Fatal("expected validation error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
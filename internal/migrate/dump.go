@@ -0,0 +1,322 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/crypt"
+)
+
+// dumpHeader starts every dump file; split dumps use it to recognise files they own.
+const dumpHeader = "-- mysql-user-migrate dump"
+
+// AccountDump is the canonical text of one account.
+type AccountDump struct {
+	Identity Identity
+	SQL      string
+}
+
+// FileName is the account's file name in split dumps.
+func (a AccountDump) FileName() string {
+	return fileSafe(a.Identity.String()) + ".sql"
+}
+
+// Dump renders every account in a canonical form, in the style of pt-show-grants, so that
+// dumps taken on different days or server versions differ only where accounts changed:
+// accounts are sorted by user and host, grants on the same object are merged, privileges and
+// columns are sorted, names are quoted one way, and redundant USAGE grants are dropped.
+// Attributes that have no portable SQL form are listed in a comment. With redact, password
+// hashes are replaced by a placeholder.
+func (s *Snapshot) Dump(redact bool) ([]AccountDump, error) {
+	users, err := s.Users()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].User != users[j].User {
+			return users[i].User < users[j].User
+		}
+		return users[i].Host < users[j].Host
+	})
+
+	out := make([]AccountDump, 0, len(users))
+	for _, u := range users {
+		id := Identity{User: u.User, Host: u.Host}
+		grants, err := canonicalGrants(u.Grants)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", id, err)
+		}
+
+		var b strings.Builder
+		kind := "user"
+		if u.Role {
+			kind = "role"
+		}
+		fmt.Fprintf(&b, "-- %s %s\n", kind, id.Quoted())
+		if attrs := dumpAttributes(u.Attributes); attrs != "" {
+			fmt.Fprintf(&b, "-- attributes: %s\n", attrs)
+		}
+		b.WriteString("CREATE USER " + id.Quoted())
+		if u.Plugin != "" {
+			fmt.Fprintf(&b, " IDENTIFIED WITH '%s'", escape(u.Plugin))
+			if u.AuthString != "" {
+				if redact {
+					b.WriteString(" AS '<redacted>'")
+				} else {
+					b.WriteString(" AS " + authLiteral(u.AuthString))
+				}
+			}
+		}
+		b.WriteString(";\n")
+		for _, g := range grants {
+			b.WriteString(g + ";\n")
+		}
+		if len(u.DefaultRoles) > 0 {
+			roles := make([]string, 0, len(u.DefaultRoles))
+			for _, r := range u.DefaultRoles {
+				at := strings.LastIndex(r, "@")
+				roles = append(roles, Identity{User: r[:at], Host: r[at+1:]}.Quoted())
+			}
+			sort.Strings(roles)
+			fmt.Fprintf(&b, "SET DEFAULT ROLE %s TO %s;\n", strings.Join(roles, ", "), id.Quoted())
+		}
+		out = append(out, AccountDump{Identity: id, SQL: b.String()})
+	}
+	return out, nil
+}
+
+// DumpHeader is the header of a dump file. It carries no timestamp, so unchanged accounts
+// produce identical files.
+func (s *Snapshot) DumpHeader() string {
+	header := fmt.Sprintf("%s of %s\n", dumpHeader, s.Source)
+	if s.SourceVersion != "" {
+		header += fmt.Sprintf("-- server version: %s\n", s.SourceVersion)
+	}
+	return header
+}
+
+// RenderDump joins account dumps into a single file.
+func (s *Snapshot) RenderDump(accounts []AccountDump) []byte {
+	var b strings.Builder
+	b.WriteString(s.DumpHeader())
+	for _, a := range accounts {
+		b.WriteString("\n")
+		b.WriteString(a.SQL)
+	}
+	return []byte(b.String())
+}
+
+// WriteDumpDir writes one file per account to dir, sealed when a key is configured, and
+// removes files of earlier dumps whose accounts no longer exist. Other files are left alone.
+func (s *Snapshot) WriteDumpDir(dir string, accounts []AccountDump, key *crypt.Key) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("write dump: %w", err)
+	}
+	current := make(map[string]bool, len(accounts))
+	for _, a := range accounts {
+		name := a.FileName()
+		if key != nil {
+			name += ".enc"
+		}
+		current[name] = true
+		data := s.DumpHeader() + "\n" + a.SQL
+		if err := crypt.WriteFile(filepath.Join(dir, name), []byte(data), key); err != nil {
+			return fmt.Errorf("write dump: %w", err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("write dump: %w", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || current[name] || !(strings.HasSuffix(name, ".sql") || strings.HasSuffix(name, ".sql.enc")) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		data, err := crypt.ReadFile(path, key)
+		if errors.Is(err, crypt.ErrNotSealed) {
+			// Plaintext dumps from before encryption was configured are stale too.
+			data, err = os.ReadFile(path)
+		}
+		if err != nil || !strings.HasPrefix(string(data), dumpHeader) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("write dump: %w", err)
+		}
+	}
+	return nil
+}
+
+// dumpAttributes lists non-default attributes as sorted key=value pairs.
+func dumpAttributes(attrs map[string]string) string {
+	keys := make([]string, 0, len(attrs))
+	for k, v := range attrs {
+		if v != "" && v != "0" && v != "N" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + attrs[k]
+	}
+	return strings.Join(parts, " ")
+}
+
+// Grant ordering in dumps: global, database, table and routine privileges, then proxies and
+// roles, then partial revokes in the same object order.
+const (
+	rankGlobal = iota
+	rankDatabase
+	rankTable
+	rankRoutine
+	rankProxy
+	rankRole
+	rankRevoke
+)
+
+type canonicalGrant struct {
+	rank  int
+	key   string // object or role, for ordering and merging
+	grant Grant
+}
+
+// canonicalGrants merges, sorts and normalizes grants and partial revokes. Trailing clauses
+// such as 5.6 IDENTIFIED BY PASSWORD or resource limits are dropped; the account line
+// carries them.
+func canonicalGrants(raws []string) ([]string, error) {
+	merged := make(map[string]*canonicalGrant)
+	var order []*canonicalGrant
+	add := func(c canonicalGrant) {
+		mergeKey := fmt.Sprintf("%d|%s|%v|%v|%v", c.rank, c.key, c.grant.GrantOption, c.grant.AdminOption, c.grant.Revoke)
+		if prev, ok := merged[mergeKey]; ok {
+			prev.grant.Privileges = append(prev.grant.Privileges, c.grant.Privileges...)
+			return
+		}
+		merged[mergeKey] = &c
+		order = append(order, &c)
+	}
+
+	for _, raw := range raws {
+		g, err := parseStatement(raw)
+		if err != nil {
+			return nil, err
+		}
+		g.Extra = ""
+		switch g.Kind {
+		case RoleGrant:
+			for _, role := range g.Roles {
+				rg := g
+				rg.Roles = []Identity{role}
+				add(canonicalGrant{rank: rankRole, key: role.Quoted(), grant: rg})
+			}
+		case ProxyGrant:
+			add(canonicalGrant{rank: rankProxy, key: g.Proxied.Quoted(), grant: g})
+		default:
+			obj := g.ParseObject()
+			g.Object = canonicalObject(obj)
+			privs := make([]string, len(g.Privileges))
+			for i, p := range g.Privileges {
+				privs[i] = canonicalColumns(canonicalPrivilege(p))
+			}
+			g.Privileges = privs
+			rank := objectRank(obj)
+			if g.Revoke {
+				rank += rankRevoke
+			}
+			add(canonicalGrant{rank: rank, key: g.Object, grant: g})
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		if order[i].rank != order[j].rank {
+			return order[i].rank < order[j].rank
+		}
+		if order[i].key != order[j].key {
+			return order[i].key < order[j].key
+		}
+		return !order[i].grant.GrantOption && order[j].grant.GrantOption
+	})
+	out := make([]string, 0, len(order))
+	for _, c := range order {
+		if c.grant.Kind == PrivilegeGrant {
+			c.grant.Privileges = dedupePrivileges(c.grant.Privileges)
+		}
+		out = append(out, c.grant.String())
+	}
+	return out, nil
+}
+
+func objectRank(obj GrantObject) int {
+	switch {
+	case obj.Routine != "":
+		return rankRoutine
+	case obj.Database == "*":
+		return rankGlobal
+	case obj.Table == "*":
+		return rankDatabase
+	}
+	return rankTable
+}
+
+// canonicalObject quotes every name in an ON clause with backquotes.
+func canonicalObject(obj GrantObject) string {
+	quote := func(name string) string {
+		if name == "*" {
+			return name
+		}
+		return quoteName(name)
+	}
+	object := quote(obj.Database) + "." + quote(obj.Table)
+	if obj.Routine != "" {
+		object = obj.Routine + " " + object
+	}
+	return object
+}
+
+// canonicalColumns sorts and quotes the column list of a column privilege.
+func canonicalColumns(priv string) string {
+	name, cols := splitPrivilege(priv)
+	cols = strings.TrimSpace(cols)
+	if cols == "" {
+		return priv
+	}
+	var names []string
+	for _, col := range strings.Split(strings.Trim(cols, "()"), ",") {
+		col, _ = objectName(strings.TrimSpace(col))
+		names = append(names, quoteName(col))
+	}
+	sort.Strings(names)
+	return name + " (" + strings.Join(names, ", ") + ")"
+}
+
+// dedupePrivileges sorts privileges, drops duplicates, and drops USAGE next to real
+// privileges.
+func dedupePrivileges(privs []string) []string {
+	seen := make(map[string]bool, len(privs))
+	out := make([]string, 0, len(privs))
+	for _, p := range privs {
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	if len(out) > 1 && seen["USAGE"] {
+		filtered := out[:0]
+		for _, p := range out {
+			if p != "USAGE" {
+				filtered = append(filtered, p)
+			}
+		}
+		out = filtered
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,109 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotDump(t *testing.T) {
+	accounts := func(grants57, grants80 []string) *Snapshot {
+		snap := &Snapshot{Version: SnapshotVersion, Source: "src"}
+		for _, u := range []UserRecord{
+			{User: "web", Host: "%", Plugin: "mysql_native_password", AuthString: "*ABCD", Grants: grants57,
+				Attributes: map[string]string{"max_user_connections": "20", "account_locked": "N"}, DefaultRoles: []string{"reader@%"}},
+			{User: "app", Host: "10.0.%", Plugin: "caching_sha2_password", AuthString: "$A$005$\x01\x02", Grants: grants80},
+		} {
+			snap.Accounts = append(snap.Accounts, snapshotAccount(u))
+		}
+		return snap
+	}
+	a := accounts([]string{
+		"GRANT USAGE ON *.* TO 'web'@'%' IDENTIFIED BY PASSWORD '*ABCD'",
+		"GRANT UPDATE, SELECT ON `shop`.* TO 'web'@'%'",
+		"GRANT SELECT (total, `id`) ON shop.orders TO 'web'@'%'",
+		"GRANT `reader`@`%`,`writer`@`%` TO `web`@`%`",
+		"REVOKE DELETE, insert ON `mysql`.* FROM 'web'@'%'",
+		"REVOKE SELECT ON *.* FROM 'web'@'%'",
+	}, []string{
+		"GRANT PROCESS ON *.* TO `app`@`10.0.%`",
+		"GRANT EXECUTE ON PROCEDURE `shop`.`refund` TO `app`@`10.0.%`",
+	})
+	b := accounts([]string{
+		"GRANT `writer`@`%` TO `web`@`%`",
+		"GRANT SELECT (`id`, `total`) ON `shop`.`orders` TO `web`@`%`",
+		"GRANT SELECT ON `shop`.* TO `web`@`%`",
+		"GRANT USAGE ON *.* TO `web`@`%`",
+		"GRANT `reader`@`%` TO `web`@`%`",
+		"GRANT UPDATE ON `shop`.* TO `web`@`%`",
+		"REVOKE INSERT ON mysql.* FROM `web`@`%`",
+		"REVOKE SELECT ON *.* FROM `web`@`%`",
+		"REVOKE DELETE ON `mysql`.* FROM `web`@`%`",
+	}, []string{
+		"GRANT EXECUTE ON PROCEDURE shop.refund TO 'app'@'10.0.%'",
+		"GRANT USAGE ON *.* TO 'app'@'10.0.%'",
+		"GRANT PROCESS ON *.* TO 'app'@'10.0.%'",
+	})
+
+	dumpA, err := a.Dump(false)
+	if err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	dumpB, err := b.Dump(false)
+	if err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	if got, other := string(a.RenderDump(dumpA)), string(b.RenderDump(dumpB)); got != other {
+		t.Fatalf("equivalent accounts dump differently:\n%s\n---\n%s", got, other)
+	}
+
+	want := "-- user 'web'@'%'\n" +
+		"-- attributes: max_user_connections=20\n" +
+		"CREATE USER 'web'@'%' IDENTIFIED WITH 'mysql_native_password' AS '*ABCD';\n" +
+		"GRANT USAGE ON *.* TO 'web'@'%';\n" +
+		"GRANT SELECT, UPDATE ON `shop`.* TO 'web'@'%';\n" +
+		"GRANT SELECT (`id`, `total`) ON `shop`.`orders` TO 'web'@'%';\n" +
+		"GRANT 'reader'@'%' TO 'web'@'%';\n" +
+		"GRANT 'writer'@'%' TO 'web'@'%';\n" +
+		"REVOKE SELECT ON *.* FROM 'web'@'%';\n" +
+		"REVOKE DELETE, INSERT ON `mysql`.* FROM 'web'@'%';\n" +
+		"SET DEFAULT ROLE 'reader'@'%' TO 'web'@'%';\n"
+	if len(dumpA) != 2 || dumpA[0].Identity.User != "app" || dumpA[1].SQL != want {
+		t.Fatalf("dump =\n%s\nwant\n%s", dumpA[1].SQL, want)
+	}
+	if !strings.Contains(dumpA[0].SQL, "AS 0x244124303035240102;") || strings.Contains(dumpA[0].SQL, "USAGE") {
+		t.Fatalf("app dump =\n%s", dumpA[0].SQL)
+	}
+
+	redacted, err := a.Dump(true)
+	if err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	if strings.Contains(redacted[1].SQL, "*ABCD") || !strings.Contains(redacted[1].SQL, "AS '<redacted>'") {
+		t.Fatalf("redacted dump =\n%s", redacted[1].SQL)
+	}
+}
+
+func TestWriteDumpDir(t *testing.T) {
+	dir := t.TempDir()
+	snap := &Snapshot{Version: SnapshotVersion, Source: "src"}
+	stale := filepath.Join(dir, "old@%.sql")
+	notes := filepath.Join(dir, "notes.sql")
+	os.WriteFile(stale, []byte(snap.DumpHeader()), 0o600)
+	os.WriteFile(notes, []byte("-- hand written\n"), 0o600)
+
+	accounts := []AccountDump{{Identity: Identity{User: "app", Host: "%"}, SQL: "CREATE USER 'app'@'%';\n"}}
+	if err := snap.WriteDumpDir(dir, accounts, nil); err != nil {
+		t.Fatalf("WriteDumpDir: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app@%.sql")); err != nil {
+		t.Fatalf("account file: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("stale dump file should be removed")
+	}
+	if _, err := os.Stat(notes); err != nil {
+		t.Fatalf("unrelated file should be kept: %v", err)
+	}
+}
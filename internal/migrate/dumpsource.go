@@ -0,0 +1,530 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/crypt"
+)
+
+// DumpSource reads accounts from mysqldump output of the mysql system schema: INSERT rows for
+// mysql.user and the privilege tables are turned back into accounts and GRANT statements.
+// Column layouts come from the CREATE TABLE statements or INSERT column lists in the dump,
+// falling back to the 5.6 and 5.7 layouts when neither is present. Key opens encrypted dumps.
+type DumpSource struct {
+	Paths   []string
+	Key     *crypt.Key
+	version string
+}
+
+// Load parses the dumps and returns the matching accounts.
+func (s *DumpSource) Load(_ context.Context, match func(user, host string) bool) ([]UserRecord, error) {
+	dump := newSchemaDump()
+	for _, path := range s.Paths {
+		data, err := crypt.ReadFile(path, s.Key)
+		if err != nil {
+			return nil, fmt.Errorf("read dump %s: %w", path, err)
+		}
+		if err := dump.parse(string(data)); err != nil {
+			return nil, fmt.Errorf("dump %s: %w", path, err)
+		}
+		if v := dumpServerVersion(string(data)); v != "" {
+			s.version = v
+		}
+	}
+	users, err := dump.users()
+	if err != nil {
+		return nil, err
+	}
+	return filterUsers(users, match), nil
+}
+
+// Describe names the dump files.
+func (s *DumpSource) Describe() string {
+	if len(s.Paths) == 1 {
+		return "mysql schema dump " + s.Paths[0]
+	}
+	return "mysql schema dumps " + strings.Join(s.Paths, ", ")
+}
+
+// ServerVersion returns the version from the "-- Server version" header line, if any.
+func (s *DumpSource) ServerVersion() string {
+	return s.version
+}
+
+func dumpServerVersion(dump string) string {
+	for _, line := range strings.SplitN(dump, "\n", 20) {
+		if v, ok := strings.CutPrefix(line, "-- Server version"); ok {
+			if fields := strings.Fields(v); len(fields) > 0 {
+				return fields[0]
+			}
+		}
+	}
+	return ""
+}
+
+// privilegeTables are the mysql tables read from a dump.
+var privilegeTables = map[string]bool{
+	"user":          true,
+	"db":            true,
+	"tables_priv":   true,
+	"columns_priv":  true,
+	"procs_priv":    true,
+	"proxies_priv":  true,
+	"global_grants": true,
+	"role_edges":    true,
+	"default_roles": true,
+}
+
+var userPrivilegeColumns = []string{
+	"select_priv", "insert_priv", "update_priv", "delete_priv", "create_priv", "drop_priv",
+	"reload_priv", "shutdown_priv", "process_priv", "file_priv", "grant_priv",
+	"references_priv", "index_priv", "alter_priv", "show_db_priv", "super_priv",
+	"create_tmp_table_priv", "lock_tables_priv", "execute_priv", "repl_slave_priv",
+	"repl_client_priv", "create_view_priv", "show_view_priv", "create_routine_priv",
+	"alter_routine_priv", "create_user_priv", "event_priv", "trigger_priv",
+	"create_tablespace_priv",
+}
+
+// fallbackLayouts are the column orders used when a dump has neither CREATE TABLE statements
+// nor INSERT column lists. mysql.user is told apart by its column count.
+var fallbackLayouts = map[string][][]string{
+	"user": {
+		// 5.6
+		concat([]string{"host", "user", "password"}, userPrivilegeColumns, []string{
+			"ssl_type", "ssl_cipher", "x509_issuer", "x509_subject", "max_questions",
+			"max_updates", "max_connections", "max_user_connections", "plugin",
+			"authentication_string", "password_expired",
+		}),
+		// 5.7
+		concat([]string{"host", "user"}, userPrivilegeColumns, []string{
+			"ssl_type", "ssl_cipher", "x509_issuer", "x509_subject", "max_questions",
+			"max_updates", "max_connections", "max_user_connections", "plugin",
+			"authentication_string", "password_expired", "password_last_changed",
+			"password_lifetime", "account_locked",
+		}),
+	},
+	"db": {{
+		"host", "db", "user", "select_priv", "insert_priv", "update_priv", "delete_priv",
+		"create_priv", "drop_priv", "grant_priv", "references_priv", "index_priv",
+		"alter_priv", "create_tmp_table_priv", "lock_tables_priv", "create_view_priv",
+		"show_view_priv", "create_routine_priv", "alter_routine_priv", "execute_priv",
+		"event_priv", "trigger_priv",
+	}},
+	"tables_priv":   {{"host", "db", "user", "table_name", "grantor", "timestamp", "table_priv", "column_priv"}},
+	"columns_priv":  {{"host", "db", "user", "table_name", "column_name", "timestamp", "column_priv"}},
+	"procs_priv":    {{"host", "db", "user", "routine_name", "routine_type", "grantor", "proc_priv", "timestamp"}},
+	"proxies_priv":  {{"host", "user", "proxied_host", "proxied_user", "with_grant", "grantor", "timestamp"}},
+	"global_grants": {{"user", "host", "priv", "with_grant_option"}},
+	"role_edges":    {{"from_host", "from_user", "to_host", "to_user", "with_admin_option"}},
+	"default_roles": {{"host", "user", "default_role_host", "default_role_user"}},
+}
+
+func concat(parts ...[]string) []string {
+	var out []string
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// privilegeColumnOrder lists every privilege column, 8.0 additions included. Grant_priv is
+// the grant option rather than a privilege.
+var privilegeColumnOrder = concat(userPrivilegeColumns[:10], userPrivilegeColumns[11:], []string{"create_role_priv", "drop_role_priv"})
+
+// privilegeNames maps privilege columns to privilege names.
+var privilegeNames = map[string]string{
+	"select_priv":            "SELECT",
+	"insert_priv":            "INSERT",
+	"update_priv":            "UPDATE",
+	"delete_priv":            "DELETE",
+	"create_priv":            "CREATE",
+	"drop_priv":              "DROP",
+	"reload_priv":            "RELOAD",
+	"shutdown_priv":          "SHUTDOWN",
+	"process_priv":           "PROCESS",
+	"file_priv":              "FILE",
+	"references_priv":        "REFERENCES",
+	"index_priv":             "INDEX",
+	"alter_priv":             "ALTER",
+	"show_db_priv":           "SHOW DATABASES",
+	"super_priv":             "SUPER",
+	"create_tmp_table_priv":  "CREATE TEMPORARY TABLES",
+	"lock_tables_priv":       "LOCK TABLES",
+	"execute_priv":           "EXECUTE",
+	"repl_slave_priv":        "REPLICATION SLAVE",
+	"repl_client_priv":       "REPLICATION CLIENT",
+	"create_view_priv":       "CREATE VIEW",
+	"show_view_priv":         "SHOW VIEW",
+	"create_routine_priv":    "CREATE ROUTINE",
+	"alter_routine_priv":     "ALTER ROUTINE",
+	"create_user_priv":       "CREATE USER",
+	"event_priv":             "EVENT",
+	"trigger_priv":           "TRIGGER",
+	"create_tablespace_priv": "CREATE TABLESPACE",
+	"create_role_priv":       "CREATE ROLE",
+	"drop_role_priv":         "DROP ROLE",
+}
+
+type dumpRow map[string]string
+
+// schemaDump collects privilege-table rows from one or more dumps.
+type schemaDump struct {
+	columns map[string][]string // from CREATE TABLE
+	rows    map[string][]dumpRow
+	db      string // current database from USE
+}
+
+func newSchemaDump() *schemaDump {
+	return &schemaDump{columns: make(map[string][]string), rows: make(map[string][]dumpRow)}
+}
+
+func (d *schemaDump) parse(dump string) error {
+	for _, stmt := range splitStatements(dump) {
+		if err := d.statement(stmt); err != nil {
+			return fmt.Errorf("%w: %s", err, abbreviate(stmt))
+		}
+	}
+	return nil
+}
+
+func (d *schemaDump) statement(stmt string) error {
+	// Only USE, CREATE TABLE and INSERT matter; skip everything else before tokenizing.
+	upper := strings.ToUpper(stmt[:min(len(stmt), 32)])
+	if !strings.HasPrefix(upper, "USE ") && !strings.HasPrefix(upper, "CREATE TABLE") &&
+		!strings.HasPrefix(upper, "INSERT ") && !strings.HasPrefix(upper, "REPLACE ") {
+		return nil
+	}
+	toks, err := tokenize(stmt)
+	if err != nil {
+		return err
+	}
+	s := &tokenStream{toks: toks}
+	switch {
+	case s.accept("USE"):
+		d.db = s.next().text
+		return nil
+	case s.accept("CREATE", "TABLE"):
+		s.accept("IF", "NOT", "EXISTS")
+		table, ok := d.table(s)
+		if !ok {
+			return nil
+		}
+		d.columns[table] = readColumnDefinitions(s)
+		return nil
+	}
+	if !s.accept("INSERT") && !s.accept("REPLACE") {
+		return nil
+	}
+	s.accept("IGNORE")
+	if !s.accept("INTO") {
+		return fmt.Errorf("expected INTO")
+	}
+	table, ok := d.table(s)
+	if !ok {
+		return nil
+	}
+	columns := d.columns[table]
+	if s.peek().is("(") {
+		s.next()
+		columns = nil
+		for !s.done() && !s.peek().is(")") {
+			if t := s.next(); !t.is(",") {
+				columns = append(columns, strings.ToLower(t.text))
+			}
+		}
+		s.next()
+	}
+	if !s.accept("VALUES") {
+		return fmt.Errorf("expected VALUES")
+	}
+	for !s.done() {
+		values, err := readTuple(s)
+		if err != nil {
+			return err
+		}
+		layout, err := rowLayout(table, columns, len(values))
+		if err != nil {
+			return err
+		}
+		row := make(dumpRow, len(values))
+		for i, col := range layout {
+			row[col] = values[i]
+		}
+		d.rows[table] = append(d.rows[table], row)
+		s.accept(",")
+	}
+	return nil
+}
+
+// table reads a table name and reports whether it is a mysql privilege table. Unqualified
+// names count when the dump selected the mysql database, or selected none.
+func (d *schemaDump) table(s *tokenStream) (string, bool) {
+	db, name := d.db, s.next().text
+	if s.peek().is(".") {
+		s.next()
+		db, name = name, s.next().text
+	} else if before, after, ok := strings.Cut(name, "."); ok {
+		db, name = before, after
+	}
+	name = strings.ToLower(name)
+	return name, (db == "" || strings.EqualFold(db, "mysql")) && privilegeTables[name]
+}
+
+// readColumnDefinitions returns the column names from a CREATE TABLE body, skipping keys
+// and constraints.
+func readColumnDefinitions(s *tokenStream) []string {
+	if !s.accept("(") {
+		return nil
+	}
+	var columns []string
+	depth, itemStart := 1, true
+	for !s.done() && depth > 0 {
+		t := s.next()
+		switch {
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		case t.is(",") && depth == 1:
+			itemStart = true
+			continue
+		case itemStart && t.kind == tokIdent:
+			columns = append(columns, strings.ToLower(t.text))
+		}
+		itemStart = false
+	}
+	return columns
+}
+
+// readTuple reads one parenthesised VALUES tuple. NULL becomes an empty string.
+func readTuple(s *tokenStream) ([]string, error) {
+	if !s.accept("(") {
+		return nil, fmt.Errorf("expected ( in VALUES, got %q", s.peek().text)
+	}
+	var values []string
+	for {
+		t := s.next()
+		if t.kind == tokWord && strings.HasPrefix(t.text, "_") && s.peek().kind == tokString {
+			// Character set introducer, as in _binary '...'.
+			t = s.next()
+		}
+		switch {
+		case t.kind == tokPunct:
+			return nil, fmt.Errorf("unexpected %q in VALUES", t.text)
+		case t.is("NULL"):
+			values = append(values, "")
+		default:
+			values = append(values, t.text)
+		}
+		if s.accept(")") {
+			return values, nil
+		}
+		if !s.accept(",") {
+			return nil, fmt.Errorf("expected , or ) in VALUES, got %q", s.peek().text)
+		}
+	}
+}
+
+func rowLayout(table string, columns []string, n int) ([]string, error) {
+	if columns != nil {
+		if len(columns) != n {
+			return nil, fmt.Errorf("%s row has %d values for %d columns", table, n, len(columns))
+		}
+		return columns, nil
+	}
+	for _, layout := range fallbackLayouts[table] {
+		if len(layout) == n {
+			return layout, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown %d-column layout for mysql.%s; include its CREATE TABLE statement in the dump", n, table)
+}
+
+// users rebuilds the accounts, with grants in SHOW GRANTS order: global, dynamic, database,
+// table, routine, proxy and role grants.
+func (d *schemaDump) users() ([]UserRecord, error) {
+	grants := make(map[Identity][]string)
+	add := func(id Identity, g Grant) {
+		grants[id] = append(grants[id], g.String())
+	}
+	rowIdentity := func(row dumpRow) Identity {
+		return Identity{User: row["user"], Host: row["host"]}
+	}
+
+	for _, row := range d.rows["user"] {
+		privs, grantOption := privilegeColumns(row)
+		add(rowIdentity(row), Grant{Privileges: privs, Object: "*.*", Grantee: rowIdentity(row), GrantOption: grantOption})
+	}
+	for _, row := range d.rows["global_grants"] {
+		id := rowIdentity(row)
+		add(id, Grant{Privileges: []string{strings.ToUpper(row["priv"])}, Object: "*.*", Grantee: id, GrantOption: row["with_grant_option"] == "Y"})
+	}
+	for _, row := range d.rows["db"] {
+		privs, grantOption := privilegeColumns(row)
+		if len(privs) == 1 && privs[0] == "USAGE" && !grantOption {
+			continue
+		}
+		add(rowIdentity(row), Grant{Privileges: privs, Object: quoteName(row["db"]) + ".*", Grantee: rowIdentity(row), GrantOption: grantOption})
+	}
+	for _, g := range d.tableGrants() {
+		add(g.Grantee, g)
+	}
+	for _, row := range d.rows["procs_priv"] {
+		privs, grantOption := setPrivileges(row["proc_priv"])
+		object := fmt.Sprintf("%s %s.%s", strings.ToUpper(row["routine_type"]), quoteName(row["db"]), quoteName(row["routine_name"]))
+		add(rowIdentity(row), Grant{Privileges: privs, Object: object, Grantee: rowIdentity(row), GrantOption: grantOption})
+	}
+	for _, row := range d.rows["proxies_priv"] {
+		if row["proxied_user"] == "" && row["proxied_host"] == "" {
+			continue
+		}
+		id := rowIdentity(row)
+		add(id, Grant{Kind: ProxyGrant, Privileges: []string{"PROXY"}, Proxied: Identity{User: row["proxied_user"], Host: row["proxied_host"]}, Grantee: id, GrantOption: row["with_grant"] == "1"})
+	}
+	roles := make(map[Identity]bool)
+	for _, row := range d.rows["role_edges"] {
+		role := Identity{User: row["from_user"], Host: row["from_host"]}
+		id := Identity{User: row["to_user"], Host: row["to_host"]}
+		roles[role] = true
+		add(id, Grant{Kind: RoleGrant, Roles: []Identity{role}, Grantee: id, AdminOption: row["with_admin_option"] == "Y"})
+	}
+	defaults := make(map[Identity][]string)
+	for _, row := range d.rows["default_roles"] {
+		id := rowIdentity(row)
+		defaults[id] = append(defaults[id], row["default_role_user"]+"@"+row["default_role_host"])
+	}
+
+	var users []UserRecord
+	for _, row := range d.rows["user"] {
+		id := rowIdentity(row)
+		auth := row["authentication_string"]
+		if auth == "" {
+			auth = row["password"]
+		}
+		users = append(users, UserRecord{
+			User:         id.User,
+			Host:         id.Host,
+			Plugin:       row["plugin"],
+			AuthString:   auth,
+			Grants:       grants[id],
+			RawIdentity:  id.String(),
+			Role:         roles[id],
+			DefaultRoles: defaults[id],
+			Attributes:   userAttributes(row),
+		})
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("no mysql.user rows found in dump")
+	}
+	return users, nil
+}
+
+// tableGrants merges tables_priv and columns_priv rows into one grant per table, as SHOW
+// GRANTS does: GRANT SELECT (`a`, `b`), INSERT ON `db`.`t`.
+func (d *schemaDump) tableGrants() []Grant {
+	type tableKey struct {
+		id        Identity
+		db, table string
+	}
+	var (
+		order   []tableKey
+		grants  = make(map[tableKey]*Grant)
+		columns = make(map[tableKey]map[string][]string)
+	)
+	grant := func(row dumpRow) (tableKey, *Grant) {
+		key := tableKey{id: Identity{User: row["user"], Host: row["host"]}, db: row["db"], table: row["table_name"]}
+		if g, ok := grants[key]; ok {
+			return key, g
+		}
+		g := &Grant{Object: quoteName(key.db) + "." + quoteName(key.table), Grantee: key.id}
+		grants[key] = g
+		order = append(order, key)
+		return key, g
+	}
+	for _, row := range d.rows["tables_priv"] {
+		_, g := grant(row)
+		privs, grantOption := setPrivileges(row["table_priv"])
+		if len(privs) > 0 && privs[0] != "USAGE" {
+			g.Privileges = append(g.Privileges, privs...)
+		}
+		g.GrantOption = g.GrantOption || grantOption
+	}
+	for _, row := range d.rows["columns_priv"] {
+		key, _ := grant(row)
+		privs, _ := setPrivileges(row["column_priv"])
+		if columns[key] == nil {
+			columns[key] = make(map[string][]string)
+		}
+		for _, p := range privs {
+			columns[key][p] = append(columns[key][p], quoteName(row["column_name"]))
+		}
+	}
+
+	var out []Grant
+	for _, key := range order {
+		g := grants[key]
+		for _, p := range []string{"SELECT", "INSERT", "UPDATE", "REFERENCES"} {
+			if cols := columns[key][p]; len(cols) > 0 {
+				g.Privileges = append(g.Privileges, fmt.Sprintf("%s (%s)", p, strings.Join(cols, ", ")))
+			}
+		}
+		if len(g.Privileges) == 0 {
+			g.Privileges = []string{"USAGE"}
+		}
+		out = append(out, *g)
+	}
+	return out
+}
+
+// privilegeColumns returns the privileges whose columns are 'Y', ALL PRIVILEGES when every
+// privilege column present in the row is set, or USAGE when none is.
+func privilegeColumns(row dumpRow) ([]string, bool) {
+	var privs []string
+	present := 0
+	for _, col := range privilegeColumnOrder {
+		value, ok := row[col]
+		if !ok {
+			continue
+		}
+		present++
+		if value == "Y" {
+			privs = append(privs, privilegeNames[col])
+		}
+	}
+	grantOption := row["grant_priv"] == "Y"
+	switch {
+	case len(privs) == 0:
+		return []string{"USAGE"}, grantOption
+	case len(privs) == present:
+		return []string{"ALL PRIVILEGES"}, grantOption
+	}
+	return privs, grantOption
+}
+
+// setPrivileges parses a SET column such as "Select,Insert,Grant".
+func setPrivileges(value string) ([]string, bool) {
+	var (
+		privs       []string
+		grantOption bool
+	)
+	for _, p := range strings.Split(value, ",") {
+		p = strings.ToUpper(strings.TrimSpace(p))
+		switch p {
+		case "":
+		case "GRANT":
+			grantOption = true
+		default:
+			privs = append(privs, p)
+		}
+	}
+	if len(privs) == 0 {
+		privs = []string{"USAGE"}
+	}
+	return privs, grantOption
+}
+
+func quoteName(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
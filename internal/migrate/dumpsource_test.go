@@ -0,0 +1,82 @@
+package migrate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDumpSource(t *testing.T) {
+	// 43-column 5.6 rows without CREATE TABLE statements.
+	dump56 := "-- Server version\t5.6.51-log\n" +
+		"INSERT INTO `user` VALUES ('%','app','*6BB4837EB74329105EE4568DDA7DC67ED2CA2AD9'," +
+		strings.Repeat("'N',", 29) + "'','','','',0,0,0,5,'mysql_native_password','','N')," +
+		"('localhost','admin','*AAAA'," + strings.Repeat("'Y',", 29) + "'','','','',0,0,0,0,'','','N');\n" +
+		"INSERT INTO `db` VALUES ('%','shop','app','Y','Y','N','N','N','N','N','N','N','N','N','N','N','N','N','N','N','N','N');\n" +
+		"INSERT INTO `tables_priv` VALUES ('%','shop','app','orders','root@localhost','2020-01-01 00:00:00','Delete','Select');\n" +
+		"INSERT INTO `columns_priv` VALUES ('%','shop','app','orders','id','2020-01-01 00:00:00','Select'),('%','shop','app','orders','total','2020-01-01 00:00:00','Select');\n" +
+		"INSERT INTO `procs_priv` VALUES ('%','shop','app','refund','PROCEDURE','root@localhost','Execute,Grant','2020-01-01 00:00:00');\n"
+
+	// 5.7 dump with a CREATE TABLE giving the layout, qualified by USE.
+	dump57 := "USE `mysql`;\n" +
+		"CREATE TABLE `user` (\n  `Host` char(60) NOT NULL DEFAULT '',\n  `User` char(32) NOT NULL DEFAULT '',\n" +
+		"  `Select_priv` enum('N','Y') NOT NULL DEFAULT 'N',\n  `Grant_priv` enum('N','Y') NOT NULL DEFAULT 'N',\n" +
+		"  `plugin` char(64) NOT NULL DEFAULT 'mysql_native_password',\n  `authentication_string` text COLLATE utf8_bin,\n" +
+		"  `account_locked` enum('N','Y') NOT NULL DEFAULT 'N',\n  PRIMARY KEY (`Host`,`User`)\n) ENGINE=MyISAM;\n" +
+		"/*!40000 ALTER TABLE `user` DISABLE KEYS */;\n" +
+		"INSERT INTO `user` VALUES ('10.0.%','svc','Y','Y','mysql_native_password','*BBBB','Y');\n"
+
+	dir := t.TempDir()
+	var paths []string
+	for i, content := range []string{dump56, dump57} {
+		path := filepath.Join(dir, []string{"mysql56.sql", "mysql57.sql"}[i])
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	src := &DumpSource{Paths: paths}
+	users, err := src.Load(context.Background(), func(user, host string) bool { return true })
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(users) != 3 {
+		t.Fatalf("loaded %d accounts: %+v", len(users), users)
+	}
+
+	app := users[0]
+	if app.RawIdentity != "app@%" || app.AuthString != "*6BB4837EB74329105EE4568DDA7DC67ED2CA2AD9" || app.Attributes["max_user_connections"] != "5" {
+		t.Fatalf("app = %+v", app)
+	}
+	wantGrants := []string{
+		"GRANT USAGE ON *.* TO 'app'@'%'",
+		"GRANT SELECT, INSERT ON `shop`.* TO 'app'@'%'",
+		"GRANT DELETE, SELECT (`id`, `total`) ON `shop`.`orders` TO 'app'@'%'",
+		"GRANT EXECUTE ON PROCEDURE `shop`.`refund` TO 'app'@'%' WITH GRANT OPTION",
+	}
+	if !reflect.DeepEqual(app.Grants, wantGrants) {
+		t.Fatalf("app grants:\n%s\nwant:\n%s", strings.Join(app.Grants, "\n"), strings.Join(wantGrants, "\n"))
+	}
+
+	admin := users[1]
+	if admin.AuthString != "*AAAA" || len(admin.Grants) != 1 || admin.Grants[0] != "GRANT ALL PRIVILEGES ON *.* TO 'admin'@'localhost' WITH GRANT OPTION" {
+		t.Fatalf("admin = %+v", admin)
+	}
+
+	svc := users[2]
+	if svc.AuthString != "*BBBB" || svc.Attributes["account_locked"] != "Y" || svc.Grants[0] != "GRANT ALL PRIVILEGES ON *.* TO 'svc'@'10.0.%' WITH GRANT OPTION" {
+		t.Fatalf("svc = %+v", svc)
+	}
+}
+
+func TestDumpSourceUnknownLayout(t *testing.T) {
+	d := newSchemaDump()
+	err := d.parse("INSERT INTO `mysql`.`user` VALUES ('%','x');")
+	if err == nil || !strings.Contains(err.Error(), "CREATE TABLE") {
+		t.Fatalf("parse error = %v, want layout error", err)
+	}
+}
@@ -0,0 +1,180 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+)
+
+// fakeTarget is a scripted target server for runner tests. Statements containing a key of
+// failures fail with that MySQL error number; those containing a key of deadlocks fail with
+// a deadlock that many times first, and those containing a key of stalls wait until their
+// context is done. Everything executed is logged.
+type fakeTarget struct {
+	mu        sync.Mutex
+	accounts  map[Identity]bool
+	failures  map[string]uint16
+	deadlocks map[string]int
+	stalls    map[string]bool
+	execs     []string
+	onExec    func(stmt string) // called after a statement succeeds
+}
+
+var (
+	fakeTargets  sync.Map // DSN -> *fakeTarget
+	registerFake sync.Once
+)
+
+// newFakeTarget returns a target served by a fakeTarget holding accounts.
+func newFakeTarget(t *testing.T, name string, accounts ...Identity) (config.Target, *fakeTarget) {
+	t.Helper()
+	registerFake.Do(func() { sql.Register("fakemysql", fakeDriver{}) })
+	prev := driverName
+	driverName = "fakemysql"
+	t.Cleanup(func() { driverName = prev })
+
+	ft := &fakeTarget{accounts: make(map[Identity]bool), failures: make(map[string]uint16), deadlocks: make(map[string]int), stalls: make(map[string]bool)}
+	for _, id := range accounts {
+		ft.accounts[id] = true
+	}
+	dsn := t.Name() + "/" + name
+	fakeTargets.Store(dsn, ft)
+	t.Cleanup(func() { fakeTargets.Delete(dsn) })
+	return config.Target{Name: name, DSN: dsn}, ft
+}
+
+func (f *fakeTarget) executed() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.execs...)
+}
+
+func (f *fakeTarget) stalled(stmt string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key := range f.stalls {
+		if strings.Contains(stmt, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeTarget) exec(stmt string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, num := range f.failures {
+		if strings.Contains(stmt, key) {
+			return &mysql.MySQLError{Number: num, Message: "fake failure"}
+		}
+	}
+	for key, n := range f.deadlocks {
+		if n > 0 && strings.Contains(stmt, key) {
+			f.deadlocks[key] = n - 1
+			return &mysql.MySQLError{Number: 1213, Message: "fake deadlock"}
+		}
+	}
+	f.execs = append(f.execs, stmt)
+	for id := range f.accounts {
+		if strings.HasPrefix(stmt, "DROP USER") && strings.Contains(stmt, id.Quoted()) {
+			delete(f.accounts, id)
+		}
+	}
+	if rest, ok := strings.CutPrefix(stmt, "CREATE USER "); ok {
+		if id, _, err := readIdentity(strings.TrimPrefix(rest, "IF NOT EXISTS ")); err == nil {
+			f.accounts[id] = true
+		}
+	}
+	if f.onExec != nil {
+		f.onExec(stmt)
+	}
+	return nil
+}
+
+func (f *fakeTarget) query(stmt string, args []driver.NamedValue) (driver.Rows, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case strings.Contains(stmt, "FROM mysql.user WHERE user=? AND host=?"):
+		n := int64(0)
+		if f.accounts[Identity{User: args[0].Value.(string), Host: args[1].Value.(string)}] {
+			n = 1
+		}
+		return &fakeRows{cols: []string{"COUNT(*)"}, rows: [][]driver.Value{{n}}}, nil
+	case strings.HasPrefix(stmt, "SHOW CREATE USER "):
+		id, _, err := readIdentity(strings.TrimPrefix(stmt, "SHOW CREATE USER "))
+		if err != nil || !f.accounts[id] {
+			return nil, &mysql.MySQLError{Number: 1396, Message: "fake: no such account"}
+		}
+		create := "CREATE USER " + id.Quoted() + " IDENTIFIED WITH 'mysql_native_password' AS '*2470C0C06DEE42FD1618BB99005ADCA2EC9D1E19'"
+		return &fakeRows{cols: []string{"CREATE USER"}, rows: [][]driver.Value{{create}}}, nil
+	case strings.HasPrefix(stmt, "SHOW GRANTS FOR "):
+		id, _, err := readIdentity(strings.TrimPrefix(stmt, "SHOW GRANTS FOR "))
+		if err != nil || !f.accounts[id] {
+			return nil, &mysql.MySQLError{Number: 1141, Message: "fake: no such grant"}
+		}
+		return &fakeRows{cols: []string{"Grants"}, rows: [][]driver.Value{{"GRANT USAGE ON *.* TO " + id.Quoted()}}}, nil
+	}
+	return nil, fmt.Errorf("fake target: unsupported query %q", stmt)
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	ft, ok := fakeTargets.Load(dsn)
+	if !ok {
+		return nil, fmt.Errorf("fake target %q not found", dsn)
+	}
+	return &fakeConn{target: ft.(*fakeTarget)}, nil
+}
+
+type fakeConn struct{ target *fakeTarget }
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *fakeConn) ExecContext(ctx context.Context, stmt string, _ []driver.NamedValue) (driver.Result, error) {
+	if c.target.stalled(stmt) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if err := c.target.exec(stmt); err != nil {
+		return nil, err
+	}
+	return driverResult{}, nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, stmt string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.target.stalled(stmt) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return c.target.query(stmt, args)
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
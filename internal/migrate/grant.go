@@ -0,0 +1,468 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Identity is a MySQL account name.
+type Identity struct {
+	User string
+	Host string
+}
+
+// String renders the identity as user@host.
+func (i Identity) String() string {
+	return i.User + "@" + i.Host
+}
+
+// Quoted renders the identity for use in SQL statements.
+func (i Identity) Quoted() string {
+	return fmt.Sprintf("'%s'@'%s'", escape(i.User), escape(i.Host))
+}
+
+// GrantKind distinguishes the statement shapes returned by SHOW GRANTS.
+type GrantKind int
+
+const (
+	// PrivilegeGrant is GRANT <privileges> ON <object> TO <grantee>.
+	PrivilegeGrant GrantKind = iota
+	// RoleGrant is GRANT <roles> TO <grantee>.
+	RoleGrant
+	// ProxyGrant is GRANT PROXY ON <account> TO <grantee>.
+	ProxyGrant
+)
+
+// Grant is a parsed GRANT statement.
+type Grant struct {
+	Kind        GrantKind
+	Privileges  []string   // e.g. "SELECT", "INSERT (`a`, `b`)"
+	Object      string     // e.g. "*.*", "`db`.*", "PROCEDURE `db`.`p`"
+	Roles       []Identity // granted roles (RoleGrant)
+	Proxied     Identity   // proxied account (ProxyGrant)
+	Grantee     Identity
+	GrantOption bool
+	AdminOption bool
+	Extra       string // trailing clauses not modelled above (REQUIRE, resource limits, ...)
+	Revoke      bool   // a REVOKE ... FROM Grantee, see ParseRevoke
+}
+
+// ParseGrant parses a single GRANT statement as printed by SHOW GRANTS.
+func ParseGrant(stmt string) (Grant, error) {
+	s := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(stmt), ";"))
+	if len(s) < 6 || !strings.EqualFold(s[:6], "GRANT ") {
+		return Grant{}, fmt.Errorf("not a GRANT statement: %q", stmt)
+	}
+	s = strings.TrimSpace(s[6:])
+
+	to := indexKeyword(s, "TO")
+	if to < 0 {
+		return Grant{}, fmt.Errorf("missing TO clause: %q", stmt)
+	}
+	grantee, tail, err := readIdentity(strings.TrimSpace(s[to+2:]))
+	if err != nil {
+		return Grant{}, fmt.Errorf("grantee: %w", err)
+	}
+
+	g := Grant{Grantee: grantee}
+	tail, g.GrantOption = cutClause(tail, "GRANT OPTION")
+	tail, g.AdminOption = cutClause(tail, "ADMIN OPTION")
+	g.Extra = tail
+	if err := g.parseHead(strings.TrimSpace(s[:to]), stmt); err != nil {
+		return Grant{}, err
+	}
+	return g, nil
+}
+
+// ParseRevoke parses a REVOKE ... FROM statement, as SHOW GRANTS prints for partial revokes
+// (MySQL 8.0.16+ with partial_revokes=ON). The result has Revoke set; Grantee is the account
+// the privileges are revoked from.
+func ParseRevoke(stmt string) (Grant, error) {
+	s := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(stmt), ";"))
+	if !hasPrefixFold(s, "REVOKE ") {
+		return Grant{}, fmt.Errorf("not a REVOKE statement: %q", stmt)
+	}
+	s = strings.TrimSpace(s[7:])
+
+	from := indexKeyword(s, "FROM")
+	if from < 0 {
+		return Grant{}, fmt.Errorf("missing FROM clause: %q", stmt)
+	}
+	account, tail, err := readIdentity(strings.TrimSpace(s[from+4:]))
+	if err != nil {
+		return Grant{}, fmt.Errorf("revokee: %w", err)
+	}
+
+	g := Grant{Grantee: account, Revoke: true, Extra: tail}
+	if err := g.parseHead(strings.TrimSpace(s[:from]), stmt); err != nil {
+		return Grant{}, err
+	}
+	return g, nil
+}
+
+// parseStatement parses a GRANT or a REVOKE statement from SHOW GRANTS.
+func parseStatement(stmt string) (Grant, error) {
+	if isRevoke(stmt) {
+		return ParseRevoke(stmt)
+	}
+	return ParseGrant(stmt)
+}
+
+// isRevoke reports whether stmt is a REVOKE statement.
+func isRevoke(stmt string) bool {
+	return hasPrefixFold(strings.TrimSpace(stmt), "REVOKE ")
+}
+
+// parseHead parses what is granted or revoked: roles, PROXY ON an account, or privileges ON an
+// object.
+func (g *Grant) parseHead(head, stmt string) error {
+	on := indexKeyword(head, "ON")
+	if on < 0 {
+		g.Kind = RoleGrant
+		for _, part := range splitTopLevel(head) {
+			role, rest, err := readIdentity(part)
+			if err != nil || rest != "" {
+				return fmt.Errorf("role %q: invalid identity", part)
+			}
+			g.Roles = append(g.Roles, role)
+		}
+		return nil
+	}
+
+	for _, priv := range splitTopLevel(head[:on]) {
+		g.Privileges = append(g.Privileges, normalizePrivilege(priv))
+	}
+	if len(g.Privileges) == 0 {
+		return fmt.Errorf("no privileges: %q", stmt)
+	}
+	g.Object = strings.TrimSpace(head[on+2:])
+	if len(g.Privileges) == 1 && g.Privileges[0] == "PROXY" {
+		proxied, rest, err := readIdentity(g.Object)
+		if err != nil || rest != "" {
+			return fmt.Errorf("proxied account %q: invalid identity", g.Object)
+		}
+		g.Kind = ProxyGrant
+		g.Proxied = proxied
+		g.Object = ""
+	}
+	return nil
+}
+
+// String renders the grant as a GRANT statement, or a REVOKE statement when Revoke is set.
+func (g Grant) String() string {
+	var b strings.Builder
+	verb, to := "GRANT ", " TO "
+	if g.Revoke {
+		verb, to = "REVOKE ", " FROM "
+	}
+	b.WriteString(verb)
+	switch g.Kind {
+	case RoleGrant:
+		for i, role := range g.Roles {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(role.Quoted())
+		}
+	case ProxyGrant:
+		b.WriteString("PROXY ON ")
+		b.WriteString(g.Proxied.Quoted())
+	default:
+		b.WriteString(strings.Join(g.Privileges, ", "))
+		b.WriteString(" ON ")
+		b.WriteString(g.Object)
+	}
+	b.WriteString(to)
+	b.WriteString(g.Grantee.Quoted())
+
+	extra := g.Extra
+	var options []string
+	if g.GrantOption {
+		options = append(options, "GRANT OPTION")
+	}
+	if g.AdminOption {
+		options = append(options, "ADMIN OPTION")
+	}
+	if len(options) > 0 {
+		// Resource limits share the WITH clause on 5.6 servers.
+		if idx := indexKeyword(extra, "WITH"); idx >= 0 {
+			extra = strings.TrimSpace(extra[:idx+4] + " " + strings.Join(options, " ") + extra[idx+4:])
+		} else {
+			extra = strings.TrimSpace(extra + " WITH " + strings.Join(options, " "))
+		}
+	}
+	if extra != "" {
+		b.WriteString(" ")
+		b.WriteString(extra)
+	}
+	return b.String()
+}
+
+// mapIdentities applies fn to every account referenced by the grant.
+func (g Grant) mapIdentities(fn func(Identity) Identity) Grant {
+	g.Grantee = fn(g.Grantee)
+	if g.Kind == ProxyGrant {
+		g.Proxied = fn(g.Proxied)
+	}
+	if len(g.Roles) > 0 {
+		roles := make([]Identity, len(g.Roles))
+		for i, role := range g.Roles {
+			roles[i] = fn(role)
+		}
+		g.Roles = roles
+	}
+	return g
+}
+
+// remapGrants rewrites every account referenced in grants through fn. Statements that reference
+// no changed account, and statements other than GRANT and REVOKE, are returned verbatim.
+func remapGrants(grants []string, fn func(Identity) Identity) ([]string, error) {
+	out := make([]string, 0, len(grants))
+	for _, raw := range grants {
+		s := strings.TrimSpace(raw)
+		if !hasPrefixFold(s, "GRANT ") && !isRevoke(s) {
+			out = append(out, raw)
+			continue
+		}
+		g, err := parseStatement(raw)
+		if err != nil {
+			return nil, err
+		}
+		mapped := g.mapIdentities(fn)
+		if equalIdentities(g, mapped) {
+			out = append(out, raw)
+			continue
+		}
+		out = append(out, mapped.String())
+	}
+	return out, nil
+}
+
+func equalIdentities(a, b Grant) bool {
+	if a.Grantee != b.Grantee || a.Proxied != b.Proxied || len(a.Roles) != len(b.Roles) {
+		return false
+	}
+	for i := range a.Roles {
+		if a.Roles[i] != b.Roles[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// GrantObject is the parsed ON clause of a privilege grant. Database and Table are unquoted;
+// "*" stands for all. Routine is "PROCEDURE" or "FUNCTION" for routine grants.
+type GrantObject struct {
+	Routine  string
+	Database string
+	Table    string
+}
+
+// ParseObject splits g.Object into its parts.
+func (g Grant) ParseObject() GrantObject {
+	var obj GrantObject
+	rest := strings.TrimSpace(g.Object)
+	for _, kw := range []string{"PROCEDURE", "FUNCTION", "TABLE"} {
+		if hasPrefixFold(rest, kw+" ") {
+			if kw != "TABLE" {
+				obj.Routine = kw
+			}
+			rest = strings.TrimSpace(rest[len(kw):])
+		}
+	}
+	obj.Database, rest = objectName(rest)
+	obj.Table, _ = objectName(strings.TrimPrefix(rest, "."))
+	if obj.Table == "" {
+		obj.Table = "*"
+	}
+	return obj
+}
+
+// objectName reads a backquoted or bare name from s and returns the remainder.
+func objectName(s string) (string, string) {
+	if strings.HasPrefix(s, "`") {
+		end := quotedEnd(s, 0)
+		name, _, err := readName(s[:end])
+		if err != nil {
+			return s, ""
+		}
+		return name, s[end:]
+	}
+	if end := strings.IndexByte(s, '.'); end >= 0 {
+		return s[:end], s[end:]
+	}
+	return s, ""
+}
+
+func normalizePrivilege(p string) string {
+	p = strings.TrimSpace(p)
+	name, cols := p, ""
+	if idx := strings.Index(p, "("); idx >= 0 {
+		name, cols = strings.TrimSpace(p[:idx]), " "+p[idx:]
+	}
+	return strings.Join(strings.Fields(strings.ToUpper(name)), " ") + cols
+}
+
+// readIdentity reads a user@host account from the start of s and returns the remainder.
+func readIdentity(s string) (Identity, string, error) {
+	s = strings.TrimSpace(s)
+	user, rest, err := readName(s)
+	if err != nil {
+		return Identity{}, "", err
+	}
+	if !strings.HasPrefix(rest, "@") {
+		return Identity{User: user, Host: "%"}, strings.TrimSpace(rest), nil
+	}
+	host, rest, err := readName(rest[1:])
+	if err != nil {
+		return Identity{}, "", err
+	}
+	return Identity{User: user, Host: host}, strings.TrimSpace(rest), nil
+}
+
+// readName reads a quoted or bare account name component.
+func readName(s string) (string, string, error) {
+	if s == "" {
+		return "", "", errors.New("empty name")
+	}
+	quote := s[0]
+	if quote != '\'' && quote != '`' && quote != '"' {
+		end := strings.IndexAny(s, "@ ,")
+		if end < 0 {
+			return s, "", nil
+		}
+		return s[:end], s[end:], nil
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && quote != '`' && i+1 < len(s):
+			i++
+			b.WriteByte(unescapeByte(s[i]))
+		case c == quote && i+1 < len(s) && s[i+1] == quote:
+			i++
+			b.WriteByte(c)
+		case c == quote:
+			return b.String(), s[i+1:], nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated name %q", s)
+}
+
+func unescapeByte(c byte) byte {
+	switch c {
+	case '0':
+		return 0
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'Z':
+		return 26
+	}
+	return c
+}
+
+// cutClause removes the first top-level occurrence of clause from a WITH clause tail.
+func cutClause(tail, clause string) (string, bool) {
+	idx := indexKeyword(tail, clause)
+	if idx < 0 {
+		return tail, false
+	}
+	rest := strings.TrimSpace(tail[:idx] + tail[idx+len(clause):])
+	if strings.EqualFold(rest, "WITH") {
+		return "", true
+	}
+	if n := len(rest); n >= 5 && strings.EqualFold(rest[n-5:], " WITH") {
+		rest = strings.TrimSpace(rest[:n-5])
+	}
+	return rest, true
+}
+
+// indexKeyword finds kw as a whole word outside quotes and parentheses, case-insensitively.
+func indexKeyword(s, kw string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+			continue
+		case '(':
+			depth++
+			continue
+		case ')':
+			depth--
+			continue
+		}
+		if depth > 0 || i+len(kw) > len(s) || !strings.EqualFold(s[i:i+len(kw)], kw) {
+			continue
+		}
+		if i > 0 && isWordByte(s[i-1]) {
+			continue
+		}
+		if end := i + len(kw); end < len(s) && isWordByte(s[end]) {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// splitTopLevel splits s on commas outside quotes and parentheses.
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = appendNonEmpty(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return appendNonEmpty(parts, s[start:])
+}
+
+func appendNonEmpty(parts []string, p string) []string {
+	if p = strings.TrimSpace(p); p != "" {
+		parts = append(parts, p)
+	}
+	return parts
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
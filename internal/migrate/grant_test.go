@@ -0,0 +1,232 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+)
+
+func TestParseGrant(t *testing.T) {
+	tests := []struct {
+		name string
+		stmt string
+		want string
+	}{
+		{"global 8.0", "GRANT SELECT, INSERT ON *.* TO `app`@`10.0.%`", "GRANT SELECT, INSERT ON *.* TO 'app'@'10.0.%'"},
+		{"grant option 5.7", "GRANT ALL PRIVILEGES ON `shop`.* TO 'app'@'%' WITH GRANT OPTION", "GRANT ALL PRIVILEGES ON `shop`.* TO 'app'@'%' WITH GRANT OPTION"},
+		{"column privileges", "GRANT SELECT (`id`, `name`) ON `shop`.`orders` TO `app`@`%`", "GRANT SELECT (`id`, `name`) ON `shop`.`orders` TO 'app'@'%'"},
+		{"role grant", "GRANT `r_read`@`%`,`r_write`@`%` TO `app`@`%` WITH ADMIN OPTION", "GRANT 'r_read'@'%', 'r_write'@'%' TO 'app'@'%' WITH ADMIN OPTION"},
+		{"proxy grant", "GRANT PROXY ON ''@'' TO 'root'@'localhost' WITH GRANT OPTION", "GRANT PROXY ON ''@'' TO 'root'@'localhost' WITH GRANT OPTION"},
+		{"5.6 password", "GRANT USAGE ON *.* TO 'app'@'%' IDENTIFIED BY PASSWORD '*ABC'", "GRANT USAGE ON *.* TO 'app'@'%' IDENTIFIED BY PASSWORD '*ABC'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := ParseGrant(tt.stmt)
+			if err != nil {
+				t.Fatalf("ParseGrant(%s) error: %v", tt.stmt, err)
+			}
+			if got := g.String(); got != tt.want {
+				t.Fatalf("ParseGrant(%s).String() = %s, want %s", tt.stmt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteHost(t *testing.T) {
+	rules, err := compileHostRewrites([]config.HostRewrite{
+		{Match: "db.prod.internal", Replace: "db.stg.internal"},
+		{Match: "10.0.%", Replace: "172.16.%"},
+		{Match: "192.168.0.0/16", Replace: "10.20.0.0/16"},
+		{Match: "10.9.0.0/16", Replace: "10.99.%"},
+	})
+	if err != nil {
+		t.Fatalf("compileHostRewrites: %v", err)
+	}
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"db.prod.internal", "db.stg.internal"},
+		{"10.0.%", "172.16.%"},
+		{"10.0.5.3", "172.16.5.3"},
+		{"192.168.4.7", "10.20.4.7"},
+		{"192.168.%", "10.20.%"},
+		{"192.168.1.0/255.255.255.0", "10.20.1.0/255.255.255.0"},
+		{"10.9.3.4", "10.99.%"},
+		{"localhost", "localhost"},
+	}
+	for _, tt := range tests {
+		if got := rewriteHost(rules, tt.host); got != tt.want {
+			t.Errorf("rewriteHost(%s) = %s, want %s", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestMapUsersCollision(t *testing.T) {
+	rules, err := compileHostRewrites([]config.HostRewrite{{Match: "10.0.0.0/8", Replace: "172.16.%"}})
+	if err != nil {
+		t.Fatalf("compileHostRewrites: %v", err)
+	}
+	users := []UserRecord{
+		{User: "app", Host: "10.0.0.1", RawIdentity: "app@10.0.0.1"},
+		{User: "app", Host: "10.0.0.2", RawIdentity: "app@10.0.0.2"},
+		{User: "web", Host: "db.example.com", RawIdentity: "web@db.example.com", Grants: []string{"GRANT USAGE ON *.* TO `web`@`db.example.com`"}},
+		{User: "web", Host: "DB.example.com", RawIdentity: "web@DB.example.com"},
+		{User: "ops", Host: "10.0.0.3", RawIdentity: "ops@10.0.0.3", Grants: []string{"GRANT USAGE ON *.* TO `ops`@`10.0.0.3`"}},
+	}
+	out, problems := mapUsers(users, hostMapper(rules))
+	if out[4].Host != "172.16.%" || out[4].Grants[0] != "GRANT USAGE ON *.* TO 'ops'@'172.16.%'" {
+		t.Fatalf("unexpected mapping: %+v", out[4])
+	}
+	// Every account of a collision is reported, and host names collide regardless of case.
+	want := map[string]string{
+		"app@10.0.0.1":       "collision: app@10.0.0.1, app@10.0.0.2 map to the same account app@172.16.%",
+		"app@10.0.0.2":       "collision: app@10.0.0.1, app@10.0.0.2 map to the same account app@172.16.%",
+		"web@db.example.com": "collision: web@db.example.com, web@DB.example.com map to the same account web@db.example.com",
+		"web@DB.example.com": "collision: web@db.example.com, web@DB.example.com map to the same account web@DB.example.com",
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("problems = %v", problems)
+	}
+	for raw, msg := range want {
+		if err := problems[raw]; err == nil || err.Error() != msg {
+			t.Errorf("problem for %s = %v, want %q", raw, err, msg)
+		}
+	}
+}
+
+func TestMapUsersRename(t *testing.T) {
+	rules, err := compileUserMap([]config.UserMapping{
+		{From: "legacy_app", To: "app"},
+		{From: "legacy_*", To: "svc_*"},
+	})
+	if err != nil {
+		t.Fatalf("compileUserMap: %v", err)
+	}
+	users := []UserRecord{{
+		User:        "legacy_app",
+		Host:        "%",
+		RawIdentity: "legacy_app@%",
+		Grants: []string{
+			"GRANT SELECT ON `shop`.* TO `legacy_app`@`%`",
+			"GRANT `legacy_reader`@`%` TO `legacy_app`@`%`",
+			"GRANT PROXY ON `legacy_batch`@`%` TO `legacy_app`@`%`",
+			"REVOKE INSERT ON `mysql`.* FROM `legacy_app`@`%`",
+		},
+		DefaultRoles: []string{"legacy_reader@%", "auditor@%"},
+	}}
+	out, problems := mapUsers(users, userMapper(rules))
+	if len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+	want := []string{
+		"GRANT SELECT ON `shop`.* TO 'app'@'%'",
+		"GRANT 'svc_reader'@'%' TO 'app'@'%'",
+		"GRANT PROXY ON 'svc_batch'@'%' TO 'app'@'%'",
+		"REVOKE INSERT ON `mysql`.* FROM 'app'@'%'",
+	}
+	if out[0].User != "app" {
+		t.Fatalf("user = %s, want app", out[0].User)
+	}
+	if got := strings.Join(out[0].DefaultRoles, ","); got != "svc_reader@%,auditor@%" {
+		t.Errorf("default roles = %s", got)
+	}
+	if users[0].DefaultRoles[0] != "legacy_reader@%" {
+		t.Errorf("source record changed: %v", users[0].DefaultRoles)
+	}
+	for i, g := range out[0].Grants {
+		if g != want[i] {
+			t.Errorf("grant %d = %s, want %s", i, g, want[i])
+		}
+	}
+}
+
+func TestPrepareTargetWithoutRules(t *testing.T) {
+	grants := []string{
+		"GRANT SELECT, INSERT ON *.* TO `app`@`%`",
+		"REVOKE INSERT ON `mysql`.* FROM `app`@`%`",
+	}
+	users := []UserRecord{{User: "app", Host: "%", RawIdentity: "app@%", Grants: grants}}
+	r := &Runner{}
+	out, err := r.prepareTarget(users, nil, config.Target{Name: "t1"})
+	if err != nil {
+		t.Fatalf("prepareTarget: %v", err)
+	}
+	if out[0].Err != nil {
+		t.Fatalf("unexpected error: %v", out[0].Err)
+	}
+	for i, g := range out[0].Grants {
+		if g != grants[i] {
+			t.Errorf("grant %d = %s, want %s", i, g, grants[i])
+		}
+	}
+}
+
+func TestTransformGrants(t *testing.T) {
+	rules := compilePrivilegeRules([]config.PrivilegeRule{{
+		Name:              "readonly",
+		Targets:           []string{"reporting"},
+		Map:               map[string]string{"ALL": "SELECT"},
+		Drop:              []string{"ADMIN"},
+		RemoveGrantOption: true,
+	}}, config.Target{Name: "replica-1", Group: "reporting"})
+	if len(rules) != 1 {
+		t.Fatalf("expected rule to match target group, got %d rules", len(rules))
+	}
+
+	grants, notes, err := transformGrants([]string{
+		"GRANT ALL PRIVILEGES ON `shop`.* TO `app`@`%` WITH GRANT OPTION",
+		"GRANT SUPER, PROCESS ON *.* TO `app`@`%`",
+		"GRANT SELECT, INSERT ON `shop`.`orders` TO `app`@`%`",
+	}, rules)
+	if err != nil {
+		t.Fatalf("transformGrants: %v", err)
+	}
+	want := []string{
+		"GRANT SELECT ON `shop`.* TO 'app'@'%'",
+		"GRANT SELECT, INSERT ON `shop`.`orders` TO `app`@`%`",
+	}
+	if len(grants) != len(want) {
+		t.Fatalf("grants = %v, want %v", grants, want)
+	}
+	for i := range want {
+		if grants[i] != want[i] {
+			t.Errorf("grant %d = %s, want %s", i, grants[i], want[i])
+		}
+	}
+	if len(notes) != 5 {
+		t.Errorf("expected 5 notes, got %v", notes)
+	}
+}
+
+func TestTransformGrantsRevoke(t *testing.T) {
+	rules := compilePrivilegeRules([]config.PrivilegeRule{{
+		Name: "no-delete",
+		Map:  map[string]string{"INSERT": "INSERT, UPDATE"},
+		Drop: []string{"DELETE"},
+	}}, config.Target{Name: "t1"})
+
+	grants, notes, err := transformGrants([]string{
+		"GRANT SELECT, INSERT, DELETE ON *.* TO `app`@`%`",
+		"REVOKE INSERT, DELETE ON `mysql`.* FROM `app`@`%`",
+		"REVOKE DELETE ON `sys`.* FROM `app`@`%`",
+	}, rules)
+	if err != nil {
+		t.Fatalf("transformGrants: %v", err)
+	}
+	// The revokes follow the grant: INSERT becomes INSERT, UPDATE, and a revoke of a dropped
+	// privilege has nothing left to revoke.
+	want := []string{
+		"GRANT SELECT, INSERT, UPDATE ON *.* TO 'app'@'%'",
+		"REVOKE INSERT, UPDATE ON `mysql`.* FROM 'app'@'%'",
+	}
+	if strings.Join(grants, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("grants = %q, want %q", grants, want)
+	}
+	if last := notes[len(notes)-1]; last != "dropped revoke on `sys`.*" {
+		t.Errorf("notes = %q", notes)
+	}
+}
@@ -0,0 +1,205 @@
+package migrate
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+)
+
+// hostRule is a compiled config.HostRewrite.
+type hostRule struct {
+	match   string
+	replace string
+	pattern *regexp.Regexp // glob rules
+	prefix  netip.Prefix   // CIDR rules
+	to      netip.Prefix   // CIDR rules whose replacement is also a CIDR block
+}
+
+func compileHostRewrites(rules []config.HostRewrite) ([]hostRule, error) {
+	out := make([]hostRule, 0, len(rules))
+	for _, rule := range rules {
+		match := strings.TrimSpace(rule.Match)
+		if match == "" {
+			return nil, fmt.Errorf("host rewrite %q: empty match", rule.Match)
+		}
+		hr := hostRule{match: match, replace: strings.TrimSpace(rule.Replace)}
+		switch {
+		case strings.Contains(match, "/"):
+			prefix, err := netip.ParsePrefix(match)
+			if err != nil {
+				return nil, fmt.Errorf("host rewrite %q: %w", rule.Match, err)
+			}
+			hr.prefix = prefix.Masked()
+			if to, err := netip.ParsePrefix(hr.replace); err == nil {
+				if to.Bits() != prefix.Bits() || to.Addr().Is4() != prefix.Addr().Is4() {
+					return nil, fmt.Errorf("host rewrite %q: replacement %q must have the same prefix length", rule.Match, rule.Replace)
+				}
+				hr.to = to.Masked()
+			}
+		case strings.ContainsAny(match, "%*"):
+			hr.pattern = globRegexp(match, true)
+		}
+		out = append(out, hr)
+	}
+	return out, nil
+}
+
+// rewriteHost applies the first matching rule to host.
+func rewriteHost(rules []hostRule, host string) string {
+	for _, rule := range rules {
+		if out, ok := rule.apply(host); ok {
+			return out
+		}
+	}
+	return host
+}
+
+func (r hostRule) apply(host string) (string, bool) {
+	switch {
+	case r.prefix.IsValid():
+		return r.applyCIDR(host)
+	case r.pattern != nil:
+		groups := r.pattern.FindStringSubmatch(host)
+		if groups == nil {
+			return "", false
+		}
+		return fillWildcards(r.replace, groups[1:]), true
+	default:
+		return r.replace, strings.EqualFold(host, r.match)
+	}
+}
+
+// applyCIDR matches literal addresses, netmask hosts (10.0.0.0/255.255.0.0), CIDR hosts and
+// trailing-wildcard patterns (10.0.%) that fall inside the rule's block.
+func (r hostRule) applyCIDR(host string) (string, bool) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !r.prefix.Contains(addr) {
+			return "", false
+		}
+		if r.to.IsValid() {
+			return translateAddr(addr, r.prefix, r.to).String(), true
+		}
+		return r.replace, true
+	}
+
+	network, render, ok := hostNetwork(host)
+	if !ok || network.Bits() < r.prefix.Bits() || !r.prefix.Contains(network.Addr()) {
+		return "", false
+	}
+	if r.to.IsValid() {
+		translated := netip.PrefixFrom(translateAddr(network.Addr(), r.prefix, r.to), network.Bits())
+		return render(translated), true
+	}
+	return r.replace, true
+}
+
+// hostNetwork interprets a MySQL host value as an IPv4 network and returns a function that
+// renders a network back in the same notation.
+func hostNetwork(host string) (netip.Prefix, func(netip.Prefix) string, bool) {
+	if addrPart, maskPart, ok := strings.Cut(host, "/"); ok {
+		if prefix, err := netip.ParsePrefix(host); err == nil {
+			return prefix.Masked(), func(p netip.Prefix) string { return p.String() }, true
+		}
+		addr, err := netip.ParseAddr(addrPart)
+		mask, merr := netip.ParseAddr(maskPart)
+		if err != nil || merr != nil || !addr.Is4() || !mask.Is4() {
+			return netip.Prefix{}, nil, false
+		}
+		bits := maskBits(mask.As4())
+		if bits < 0 {
+			return netip.Prefix{}, nil, false
+		}
+		render := func(p netip.Prefix) string { return p.Addr().String() + "/" + maskPart }
+		return netip.PrefixFrom(addr, bits).Masked(), render, true
+	}
+
+	if !strings.HasSuffix(host, ".%") {
+		return netip.Prefix{}, nil, false
+	}
+	octets := strings.Split(strings.TrimSuffix(host, ".%"), ".")
+	if len(octets) == 0 || len(octets) > 3 {
+		return netip.Prefix{}, nil, false
+	}
+	var ip [4]byte
+	for i, o := range octets {
+		v, err := strconv.Atoi(o)
+		if err != nil || v < 0 || v > 255 {
+			return netip.Prefix{}, nil, false
+		}
+		ip[i] = byte(v)
+	}
+	count := len(octets)
+	render := func(p netip.Prefix) string {
+		a := p.Addr().As4()
+		parts := make([]string, 0, count+1)
+		for i := 0; i < count; i++ {
+			parts = append(parts, strconv.Itoa(int(a[i])))
+		}
+		return strings.Join(append(parts, "%"), ".")
+	}
+	return netip.PrefixFrom(netip.AddrFrom4(ip), count*8), render, true
+}
+
+// translateAddr moves addr from network from into network to, keeping the host bits.
+func translateAddr(addr netip.Addr, from, to netip.Prefix) netip.Addr {
+	src := addr.AsSlice()
+	dst := to.Addr().AsSlice()
+	bits := from.Bits()
+	for i := range src {
+		hostBits := 8 - min(max(bits-i*8, 0), 8)
+		mask := byte(1<<hostBits - 1)
+		src[i] = dst[i]&^mask | src[i]&mask
+	}
+	out, _ := netip.AddrFromSlice(src)
+	return out
+}
+
+func maskBits(mask [4]byte) int {
+	v := uint32(mask[0])<<24 | uint32(mask[1])<<16 | uint32(mask[2])<<8 | uint32(mask[3])
+	bits := 0
+	for v&(1<<31) != 0 {
+		bits++
+		v <<= 1
+	}
+	if v != 0 {
+		return -1
+	}
+	return bits
+}
+
+// globRegexp compiles a % / * pattern with one capture group per wildcard.
+func globRegexp(pattern string, fold bool) *regexp.Regexp {
+	var b strings.Builder
+	if fold {
+		b.WriteString("(?i)")
+	}
+	b.WriteString("^")
+	for _, r := range normalizePattern(pattern) {
+		if r == '*' {
+			b.WriteString("(.*)")
+			continue
+		}
+		b.WriteString(regexp.QuoteMeta(string(r)))
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// fillWildcards substitutes the wildcards of replace, in order, with captured values.
+func fillWildcards(replace string, captured []string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range replace {
+		if (r == '%' || r == '*') && n < len(captured) {
+			b.WriteString(captured[n])
+			n++
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,177 @@
+package migrate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// JournalEntry records the outcome of one account on one target.
+type JournalEntry struct {
+	Target string     `json:"target"`
+	Result UserResult `json:"result"`
+	At     time.Time  `json:"at"`
+}
+
+// Journal appends account outcomes to a JSON-lines file as they happen, so a run that dies
+// can be resumed. Outcomes loaded from an earlier run's journal are skipped when completed.
+type Journal struct {
+	mu   sync.Mutex
+	file *os.File
+	done map[journalPair]UserResult // completed outcomes
+}
+
+type journalPair struct {
+	target  string
+	account string // user@host on the target
+}
+
+// OpenJournal starts writing path. When resume names a journal, its completed outcomes are
+// loaded first, and must exist; resuming into the same file appends to it, otherwise path is
+// replaced.
+func OpenJournal(path, resume string) (*Journal, error) {
+	j := &Journal{done: make(map[journalPair]UserResult)}
+	var keep int64
+	if resume != "" {
+		var err error
+		if keep, err = j.load(resume); err != nil {
+			return nil, fmt.Errorf("resume %s: %w", resume, err)
+		}
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if path != resume {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	j.file = f
+	if path == resume {
+		err = trimJournal(f, keep)
+	} else {
+		// Outcomes carried over from another file are copied so the new journal is complete.
+		for pair, res := range j.done {
+			if err = j.write(JournalEntry{Target: pair.target, Result: res, At: time.Now()}); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// load reads a journal and returns the length of its complete lines. Later entries for a
+// pair replace earlier ones, so a failure after a success (for example a rollback) makes the
+// pair pending again.
+func (j *Journal) load(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	var (
+		bad        error
+		keep, size int64
+	)
+	for line := 1; scanner.Scan(); line++ {
+		size += int64(len(scanner.Bytes())) + 1
+		if bad != nil {
+			return 0, bad
+		}
+		if len(scanner.Bytes()) == 0 {
+			keep = size
+			continue
+		}
+		var e JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A run killed mid-write leaves a torn last line, which is dropped; anything
+			// else is corruption.
+			bad = fmt.Errorf("line %d: %w", line, err)
+			continue
+		}
+		keep = size
+		j.set(e.Target, e.Result)
+	}
+	return keep, scanner.Err()
+}
+
+// trimJournal drops a torn last line, or ends a complete one that lost its newline, so
+// appended entries start on their own line.
+func trimJournal(f *os.File, keep int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	switch {
+	case keep < info.Size():
+		return f.Truncate(keep)
+	case keep > info.Size():
+		_, err = f.Write([]byte{'\n'})
+	}
+	return err
+}
+
+func (j *Journal) set(target string, res UserResult) {
+	pair := journalPair{target, Identity{User: res.User, Host: res.Host}.String()}
+	if completed(res.Status) {
+		res.Resumed = false
+		j.done[pair] = res
+	} else {
+		delete(j.done, pair)
+	}
+}
+
+// completed reports whether an account outcome needs no retry.
+func completed(status string) bool {
+	return status == "applied" || status == "skipped"
+}
+
+// Completed returns the earlier outcome of an account on a target when it needs no retry.
+func (j *Journal) Completed(target, user, host string) (UserResult, bool) {
+	if j == nil {
+		return UserResult{}, false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	res, ok := j.done[journalPair{target, Identity{User: user, Host: host}.String()}]
+	return res, ok
+}
+
+// Record appends an outcome.
+func (j *Journal) Record(target string, res UserResult) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.set(target, res)
+	return j.write(JournalEntry{Target: target, Result: res, At: time.Now()})
+}
+
+func (j *Journal) write(e JournalEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	return nil
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
@@ -0,0 +1,113 @@
+package migrate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenJournalResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.journal")
+	lines := []string{
+		`{"target":"t1","result":{"user":"app","host":"%","status":"applied"}}`,
+		`{"target":"t1","result":{"user":"new","host":"%","status":"error"}}`,
+		`{"target":"t1","result":{"user":"old","host":"%","status":"applied"}}`,
+		`{"target":"t1","result":{"user":"old","host":"%","status":"rolled-back"}}`,
+		`{"target":"t2","result":{"user":"app","host":"%","status":"app`, // killed mid-write
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := OpenJournal(path, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		target, user string
+		want         bool
+	}{
+		{"t1", "app", true},
+		{"t1", "new", false},
+		{"t1", "old", false},
+		{"t2", "app", false},
+	}
+	for _, tt := range tests {
+		if _, ok := j.Completed(tt.target, tt.user, "%"); ok != tt.want {
+			t.Errorf("Completed(%s, %s) = %v, want %v", tt.target, tt.user, ok, tt.want)
+		}
+	}
+	if err := j.Record("t2", UserResult{User: "app", Host: "%", Status: "applied"}); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	// The appended entry starts on its own line, so the journal resumes again.
+	j, err = OpenJournal(path, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if _, ok := j.Completed("t2", "app", "%"); !ok {
+		t.Fatalf("appended entry not loaded")
+	}
+}
+
+func TestOpenJournalResumeMissing(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := OpenJournal(filepath.Join(dir, "run.journal"), filepath.Join(dir, "typo.journal")); err == nil {
+		t.Fatalf("resuming from a missing journal should fail")
+	}
+	j, err := OpenJournal(filepath.Join(dir, "run.journal"), "")
+	if err != nil {
+		t.Fatalf("a new journal should be created: %v", err)
+	}
+	j.Close()
+}
+
+func TestOpenJournalCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.journal")
+	data := "not json\n" + `{"target":"t1","result":{"user":"app","host":"%","status":"applied"}}` + "\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenJournal(path, path); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("OpenJournal error = %v", err)
+	}
+}
+
+func TestMigrateTargetResume(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.journal")
+	entry := `{"target":"t1","result":{"user":"app","host":"%","status":"applied","auth":"copied"}}` + "\n"
+	if err := os.WriteFile(first, []byte(entry), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	j, err := OpenJournal(filepath.Join(dir, "second.journal"), first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	users := []UserRecord{
+		{User: "app", Host: "%", RawIdentity: "app@%", Grants: []string{"GRANT USAGE ON *.* TO 'app'@'%'"}},
+		{User: "new", Host: "%", RawIdentity: "new@%", Grants: []string{"GRANT USAGE ON *.* TO 'new'@'%'"}},
+	}
+	target, ft := newFakeTarget(t, "t1")
+	r := &Runner{Journal: j, ForceOverwrite: true}
+	res := r.migrateTarget(context.Background(), &runState{users: users}, target)
+
+	if len(res.Users) != 2 || res.Applied != 2 || !res.Users[0].Resumed || res.Users[0].Auth != "copied" || res.Users[1].Resumed {
+		t.Fatalf("report = %+v", res)
+	}
+	if executed := strings.Join(ft.executed(), "\n"); strings.Contains(executed, "'app'") {
+		t.Fatalf("resumed account was changed again:\n%s", executed)
+	}
+	for _, user := range []string{"app", "new"} {
+		if _, ok := j.Completed("t1", user, "%"); !ok {
+			t.Errorf("%s not completed in the new journal", user)
+		}
+	}
+}
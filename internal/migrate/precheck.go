@@ -0,0 +1,361 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// heldPrivileges is what the connection's own account holds on a target, from
+// SHOW GRANTS FOR CURRENT_USER().
+type heldPrivileges struct {
+	scopes    []heldScope
+	roleAdmin map[Identity]bool // roles granted WITH ADMIN OPTION
+	proxies   map[Identity]bool // proxied accounts granted WITH GRANT OPTION
+}
+
+type heldScope struct {
+	obj         GrantObject
+	database    *regexp.Regexp // database-level grants may use % and _ wildcards
+	privs       map[string]bool
+	grantOption bool
+}
+
+// parseHeldPrivileges reads SHOW GRANTS output. Statements other than GRANT, such as partial
+// revokes, are ignored. Column privileges count for the whole table.
+func parseHeldPrivileges(grants []string) heldPrivileges {
+	held := heldPrivileges{roleAdmin: make(map[Identity]bool), proxies: make(map[Identity]bool)}
+	for _, raw := range grants {
+		g, err := ParseGrant(raw)
+		if err != nil {
+			continue
+		}
+		switch g.Kind {
+		case RoleGrant:
+			for _, role := range g.Roles {
+				held.roleAdmin[role] = held.roleAdmin[role] || g.AdminOption
+			}
+		case ProxyGrant:
+			if g.GrantOption {
+				held.proxies[g.Proxied] = true
+			}
+		default:
+			scope := heldScope{obj: g.ParseObject(), privs: make(map[string]bool), grantOption: g.GrantOption}
+			if scope.obj.Routine == "" && scope.obj.Table == "*" && scope.obj.Database != "*" {
+				scope.database = databasePattern(scope.obj.Database)
+			}
+			for _, p := range g.Privileges {
+				name, _ := splitPrivilege(canonicalPrivilege(p))
+				scope.privs[name] = true
+			}
+			scope.grantOption = scope.grantOption || scope.privs["GRANT OPTION"]
+			held.scopes = append(held.scopes, scope)
+		}
+	}
+	return held
+}
+
+// databasePattern matches database names against a database-level grant, where % and _ are
+// wildcards unless escaped.
+func databasePattern(name string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c == '\\' && i+1 < len(name):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(name[i])))
+		case c == '%':
+			b.WriteString(".*")
+		case c == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// unescapeWildcards turns a database-level grant name without % into the database it names,
+// treating _ as a literal.
+func unescapeWildcards(name string) string {
+	return strings.NewReplacer(`\_`, "_", `\%`, "%").Replace(name)
+}
+
+// covers reports whether privileges held at s apply to obj.
+func (s heldScope) covers(obj GrantObject) bool {
+	switch {
+	case s.obj.Database == "*":
+		return true
+	case s.database != nil:
+		// Required databases come from grants too, with _ and % escaped when literal.
+		return obj.Database != "*" && (s.obj.Database == obj.Database || s.database.MatchString(unescapeWildcards(obj.Database)))
+	case s.obj.Routine != "":
+		return s.obj == obj
+	}
+	return obj.Routine == "" && obj.Table != "*" && s.obj.Database == obj.Database && s.obj.Table == obj.Table
+}
+
+// has reports whether privilege priv is held on obj.
+func (h heldPrivileges) has(priv string, obj GrantObject) bool {
+	for _, s := range h.scopes {
+		if s.covers(obj) && (s.privs[priv] || (s.privs["ALL PRIVILEGES"] && priv != "GRANT OPTION")) {
+			return true
+		}
+	}
+	return false
+}
+
+// canGrant reports whether privileges on obj may be passed on.
+func (h heldPrivileges) canGrant(obj GrantObject) bool {
+	for _, s := range h.scopes {
+		if s.covers(obj) && s.grantOption {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	globalObject = GrantObject{Database: "*", Table: "*"}
+	userTable    = GrantObject{Database: "mysql", Table: "user"}
+	mysqlSchema  = GrantObject{Database: "mysql", Table: "*"}
+)
+
+// requirement is something the connection's account needs on a target to apply the plan.
+type requirement struct {
+	kind    GrantKind   // PrivilegeGrant: priv on obj; RoleGrant: grant role; ProxyGrant: grant proxy on account
+	priv    string      // privilege name, or GRANT OPTION
+	obj     GrantObject // PrivilegeGrant
+	account Identity    // role or proxied account
+}
+
+func (q requirement) String() string {
+	switch q.kind {
+	case RoleGrant:
+		return "ROLE_ADMIN ON *.* (or " + q.account.Quoted() + " WITH ADMIN OPTION)"
+	case ProxyGrant:
+		return "PROXY ON " + q.account.Quoted() + " WITH GRANT OPTION"
+	}
+	return q.priv + " ON " + canonicalObject(q.obj)
+}
+
+// satisfies reports whether the held privileges meet q.
+func (h heldPrivileges) satisfies(q requirement) bool {
+	switch q.kind {
+	case RoleGrant:
+		return h.roleAdmin[q.account] || h.has("ROLE_ADMIN", globalObject)
+	case ProxyGrant:
+		return h.proxies[q.account] || h.proxies[Identity{}]
+	case PrivilegeGrant:
+		if q.priv == "GRANT OPTION" {
+			return h.canGrant(q.obj)
+		}
+	}
+	return h.has(q.priv, q.obj)
+}
+
+// planRequirements lists what applying users needs, in order of first use, with the accounts
+// that need each. protected holds target accounts with SYSTEM_USER; backup is set when the
+// accounts are backed up first, which reads them with SHOW CREATE USER and SHOW GRANTS.
+func planRequirements(users []targetUser, protected map[Identity]bool, backup bool) ([]requirement, map[requirement][]Identity) {
+	var order []requirement
+	needed := make(map[requirement][]Identity)
+	need := func(q requirement, id Identity) {
+		if _, ok := needed[q]; !ok {
+			order = append(order, q)
+		}
+		needed[q] = append(needed[q], id)
+	}
+	privilege := func(priv string, obj GrantObject) requirement {
+		return requirement{kind: PrivilegeGrant, priv: priv, obj: obj}
+	}
+
+	for _, user := range users {
+		if user.Err != nil {
+			continue
+		}
+		id := Identity{User: user.User, Host: user.Host}
+		need(privilege("CREATE USER", globalObject), id)
+		// userExists reads mysql.user before every change.
+		need(privilege("SELECT", userTable), id)
+		if backup {
+			// Reading another account's definition and grants needs SELECT on the mysql schema.
+			need(privilege("SELECT", mysqlSchema), id)
+		}
+		if protected[id] {
+			need(privilege("SYSTEM_USER", globalObject), id)
+		}
+		for _, schema := range user.Schemas {
+			need(privilege("CREATE", GrantObject{Database: schema, Table: "*"}), id)
+		}
+		for _, raw := range user.Grants {
+			g, err := ParseGrant(raw)
+			if err != nil {
+				continue // reported when the grant is applied
+			}
+			switch g.Kind {
+			case RoleGrant:
+				for _, role := range g.Roles {
+					need(requirement{kind: RoleGrant, account: role}, id)
+				}
+			case ProxyGrant:
+				need(requirement{kind: ProxyGrant, account: g.Proxied}, id)
+			default:
+				obj := g.ParseObject()
+				delegated := false
+				for _, p := range g.Privileges {
+					name, _ := splitPrivilege(canonicalPrivilege(p))
+					if name == "USAGE" {
+						continue
+					}
+					delegated = true
+					// MySQL 8 lists an ALL PRIVILEGES account's privileges one by one, so
+					// ALL is checked as the static privileges it stands for at obj's level.
+					if name == "ALL PRIVILEGES" {
+						for _, priv := range staticPrivileges(obj) {
+							need(privilege(priv, obj), id)
+						}
+						continue
+					}
+					need(privilege(name, obj), id)
+				}
+				if delegated || g.GrantOption {
+					need(privilege("GRANT OPTION", obj), id)
+				}
+			}
+		}
+	}
+	return order, needed
+}
+
+// Static privileges that ALL PRIVILEGES grants at each level.
+var (
+	routinePrivileges  = []string{"ALTER ROUTINE", "EXECUTE"}
+	tablePrivileges    = []string{"ALTER", "CREATE", "CREATE VIEW", "DELETE", "DROP", "INDEX", "INSERT", "REFERENCES", "SELECT", "SHOW VIEW", "TRIGGER", "UPDATE"}
+	databasePrivileges = append([]string{"ALTER ROUTINE", "CREATE ROUTINE", "CREATE TEMPORARY TABLES", "EVENT", "EXECUTE", "LOCK TABLES"}, tablePrivileges...)
+	globalPrivileges   = append([]string{"CREATE ROLE", "CREATE TABLESPACE", "CREATE USER", "DROP ROLE", "FILE", "PROCESS", "RELOAD",
+		"REPLICATION CLIENT", "REPLICATION SLAVE", "SHOW DATABASES", "SHUTDOWN", "SUPER"}, databasePrivileges...)
+)
+
+// staticPrivileges returns the privileges ALL PRIVILEGES grants on obj.
+func staticPrivileges(obj GrantObject) []string {
+	switch {
+	case obj.Routine != "":
+		return routinePrivileges
+	case obj.Database == "*":
+		return globalPrivileges
+	case obj.Table == "*":
+		return databasePrivileges
+	}
+	return tablePrivileges
+}
+
+// missing lists what the connection needs but does not hold to apply users, naming the first
+// account that needs each item. protected and backup are as for planRequirements.
+func (h heldPrivileges) missing(users []targetUser, protected map[Identity]bool, backup bool) []string {
+	order, needed := planRequirements(users, protected, backup)
+	var out []string
+	for _, q := range order {
+		if h.satisfies(q) {
+			continue
+		}
+		ids := needed[q]
+		line := fmt.Sprintf("missing %s (needed by %s", q, ids[0])
+		if len(ids) > 1 {
+			line += fmt.Sprintf(" and %d more", len(ids)-1)
+		}
+		out = append(out, line+")")
+	}
+	return out
+}
+
+// precheckTarget compares the privileges of the connection's account with what backing up
+// (when backup is set) and applying users requires, so that a target that would fail is
+// blocked before any change. It runs after the pre-flight checks, which decide the databases
+// to create.
+func precheckTarget(ctx context.Context, db *sql.DB, rt *retrier, users []targetUser, backup bool) ([]string, error) {
+	grants, err := currentUserGrants(ctx, db, rt)
+	if err != nil {
+		return nil, fmt.Errorf("read own grants: %w", err)
+	}
+	protected, err := systemUsers(ctx, db, rt)
+	if err != nil {
+		return nil, fmt.Errorf("read SYSTEM_USER accounts: %w", err)
+	}
+	return parseHeldPrivileges(grants).missing(users, protected, backup), nil
+}
+
+// currentUserGrants returns SHOW GRANTS FOR CURRENT_USER(), expanded with the privileges of
+// granted roles.
+func currentUserGrants(ctx context.Context, db *sql.DB, rt *retrier) ([]string, error) {
+	grants, err := rt.queryStrings(ctx, db, "SHOW GRANTS FOR CURRENT_USER()")
+	if err != nil {
+		return nil, err
+	}
+	var roles []string
+	for _, raw := range grants {
+		if g, err := ParseGrant(raw); err == nil && g.Kind == RoleGrant {
+			for _, role := range g.Roles {
+				roles = append(roles, role.Quoted())
+			}
+		}
+	}
+	if len(roles) == 0 {
+		return grants, nil
+	}
+	return rt.queryStrings(ctx, db, "SHOW GRANTS FOR CURRENT_USER() USING "+strings.Join(roles, ", "))
+}
+
+// systemUsers returns accounts holding SYSTEM_USER, which only SYSTEM_USER accounts may modify
+// (MySQL 8.0.16+). Older servers have no such accounts; without read access to the mysql
+// schema the check is skipped.
+func systemUsers(ctx context.Context, db *sql.DB, rt *retrier) (map[Identity]bool, error) {
+	out := make(map[Identity]bool)
+	err := rt.do(ctx, func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, "SELECT USER, HOST FROM mysql.global_grants WHERE PRIV = 'SYSTEM_USER'")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id Identity
+			if err := rows.Scan(&id.User, &id.Host); err != nil {
+				return err
+			}
+			out[id] = true
+		}
+		return rows.Err()
+	})
+	var myErr *mysql.MySQLError
+	if isNoSuchTable(err) || (errors.As(err, &myErr) && myErr.Number == 1142) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func queryStrings(ctx context.Context, db querier, stmt string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,156 @@
+package migrate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPrecheckMissing(t *testing.T) {
+	users := []targetUser{
+		{UserRecord: UserRecord{User: "app", Host: "%", Grants: []string{
+			"GRANT USAGE ON *.* TO `app`@`%`",
+			"GRANT SELECT, INSERT ON `shop`.* TO `app`@`%`",
+			"GRANT SELECT (`id`) ON `crm`.`leads` TO `app`@`%`",
+			"GRANT EXECUTE ON PROCEDURE `shop`.`refund` TO `app`@`%`",
+			"GRANT `reader`@`%` TO `app`@`%`",
+		}}},
+		{UserRecord: UserRecord{User: "ops", Host: "%", Grants: []string{
+			"GRANT PROCESS ON *.* TO `ops`@`%`",
+		}}},
+		{UserRecord: UserRecord{User: "bad", Host: "%"}, Err: errors.New("collision")},
+	}
+
+	tests := []struct {
+		name      string
+		held      []string
+		protected map[Identity]bool
+		want      []string
+	}{
+		{
+			name: "root-like account",
+			held: []string{"GRANT ALL PRIVILEGES ON *.* TO `mig`@`%` WITH GRANT OPTION"},
+			want: nil,
+		},
+		{
+			name: "delegated privileges and roles",
+			held: []string{
+				"GRANT CREATE USER, ROLE_ADMIN ON *.* TO `mig`@`%`",
+				"GRANT SELECT ON `mysql`.`user` TO `mig`@`%`",
+				"GRANT SELECT, INSERT, EXECUTE ON `sh_p`.* TO `mig`@`%` WITH GRANT OPTION",
+				"GRANT SELECT ON `crm`.`leads` TO `mig`@`%`",
+			},
+			want: []string{
+				"missing GRANT OPTION ON `crm`.`leads` (needed by app@%)",
+				"missing PROCESS ON *.* (needed by ops@%)",
+				"missing GRANT OPTION ON *.* (needed by ops@%)",
+			},
+		},
+		{
+			name:      "bare account on a protected target",
+			held:      []string{"GRANT USAGE ON *.* TO `mig`@`%`"},
+			protected: map[Identity]bool{{User: "ops", Host: "%"}: true},
+			want: []string{
+				"missing CREATE USER ON *.* (needed by app@% and 1 more)",
+				"missing SELECT ON `mysql`.`user` (needed by app@% and 1 more)",
+				"missing SELECT ON `shop`.* (needed by app@%)",
+				"missing INSERT ON `shop`.* (needed by app@%)",
+				"missing GRANT OPTION ON `shop`.* (needed by app@%)",
+				"missing SELECT ON `crm`.`leads` (needed by app@%)",
+				"missing GRANT OPTION ON `crm`.`leads` (needed by app@%)",
+				"missing EXECUTE ON PROCEDURE `shop`.`refund` (needed by app@%)",
+				"missing GRANT OPTION ON PROCEDURE `shop`.`refund` (needed by app@%)",
+				"missing ROLE_ADMIN ON *.* (or 'reader'@'%' WITH ADMIN OPTION) (needed by app@%)",
+				"missing SYSTEM_USER ON *.* (needed by ops@%)",
+				"missing PROCESS ON *.* (needed by ops@%)",
+				"missing GRANT OPTION ON *.* (needed by ops@%)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseHeldPrivileges(tt.held).missing(users, tt.protected, false)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("missing =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrecheckAllPrivileges(t *testing.T) {
+	users := []targetUser{{UserRecord: UserRecord{User: "app", Host: "%", Grants: []string{
+		"GRANT ALL PRIVILEGES ON `shop`.* TO `app`@`%`",
+		"GRANT ALL PRIVILEGES ON `crm`.`leads` TO `app`@`%`",
+	}}}}
+	// SHOW GRANTS FOR root on MySQL 8 lists static and dynamic privileges instead of ALL.
+	root := []string{
+		"GRANT SELECT, INSERT, UPDATE, DELETE, CREATE, DROP, RELOAD, SHUTDOWN, PROCESS, FILE, REFERENCES, INDEX, ALTER, SHOW DATABASES, SUPER, CREATE TEMPORARY TABLES, LOCK TABLES, EXECUTE, REPLICATION SLAVE, REPLICATION CLIENT, CREATE VIEW, SHOW VIEW, CREATE ROUTINE, ALTER ROUTINE, CREATE USER, EVENT, TRIGGER, CREATE TABLESPACE, CREATE ROLE, DROP ROLE ON *.* TO `root`@`localhost` WITH GRANT OPTION",
+		"GRANT APPLICATION_PASSWORD_ADMIN,AUDIT_ADMIN,BACKUP_ADMIN,ROLE_ADMIN,SYSTEM_USER,SYSTEM_VARIABLES_ADMIN ON *.* TO `root`@`localhost` WITH GRANT OPTION",
+		"GRANT PROXY ON ``@`` TO `root`@`localhost` WITH GRANT OPTION",
+	}
+
+	tests := []struct {
+		name string
+		held []string
+		want []string
+	}{
+		{"expanded root", root, nil},
+		{
+			name: "database privileges only",
+			held: []string{
+				"GRANT CREATE USER ON *.* TO `mig`@`%`",
+				"GRANT SELECT ON `mysql`.`user` TO `mig`@`%`",
+				"GRANT SELECT, INSERT, UPDATE, DELETE ON `shop`.* TO `mig`@`%` WITH GRANT OPTION",
+				"GRANT ALL PRIVILEGES ON `crm`.`leads` TO `mig`@`%` WITH GRANT OPTION",
+			},
+			want: []string{
+				"missing ALTER ROUTINE ON `shop`.* (needed by app@%)",
+				"missing CREATE ROUTINE ON `shop`.* (needed by app@%)",
+				"missing CREATE TEMPORARY TABLES ON `shop`.* (needed by app@%)",
+				"missing EVENT ON `shop`.* (needed by app@%)",
+				"missing EXECUTE ON `shop`.* (needed by app@%)",
+				"missing LOCK TABLES ON `shop`.* (needed by app@%)",
+				"missing ALTER ON `shop`.* (needed by app@%)",
+				"missing CREATE ON `shop`.* (needed by app@%)",
+				"missing CREATE VIEW ON `shop`.* (needed by app@%)",
+				"missing DROP ON `shop`.* (needed by app@%)",
+				"missing INDEX ON `shop`.* (needed by app@%)",
+				"missing REFERENCES ON `shop`.* (needed by app@%)",
+				"missing SHOW VIEW ON `shop`.* (needed by app@%)",
+				"missing TRIGGER ON `shop`.* (needed by app@%)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseHeldPrivileges(tt.held).missing(users, nil, false)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("missing =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrecheckBackupAndSchemas(t *testing.T) {
+	users := []targetUser{{UserRecord: UserRecord{User: "app", Host: "%", Grants: []string{
+		"GRANT SELECT ON `new\\_db`.* TO `app`@`%`",
+	}}, Schemas: []string{"new_db"}}}
+	held := []string{
+		"GRANT CREATE USER ON *.* TO `mig`@`%`",
+		"GRANT SELECT ON `mysql`.`user` TO `mig`@`%`",
+		"GRANT SELECT ON `new\\_db`.* TO `mig`@`%` WITH GRANT OPTION",
+	}
+
+	got := parseHeldPrivileges(held).missing(users, nil, true)
+	want := []string{
+		"missing SELECT ON `mysql`.* (needed by app@%)",
+		"missing CREATE ON `new_db`.* (needed by app@%)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("missing =\n%q\nwant\n%q", got, want)
+	}
+	held = append(held, "GRANT SELECT ON `mysql`.* TO `mig`@`%`", "GRANT CREATE ON `new%`.* TO `mig`@`%`")
+	if got := parseHeldPrivileges(held).missing(users, nil, true); got != nil {
+		t.Fatalf("missing = %q", got)
+	}
+}
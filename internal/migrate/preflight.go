@@ -0,0 +1,270 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/netip"
+	"strings"
+	"unicode"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+)
+
+// Finding severities. Errors keep the account from being applied; warnings are reported only.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// targetServer describes what a target supports.
+type targetServer struct {
+	version             string
+	mariadb             bool
+	lowerCaseTableNames int
+	skipNameResolve     bool
+	plugins             map[string]bool // active authentication plugins
+	privileges          map[string]bool // SHOW PRIVILEGES, upper-cased
+}
+
+// loadTargetServer reads the target's version, settings, plugins and privileges.
+func loadTargetServer(ctx context.Context, db *sql.DB, rt *retrier) (*targetServer, error) {
+	s := &targetServer{plugins: make(map[string]bool), privileges: make(map[string]bool)}
+	var skip sql.NullString
+	err := rt.do(ctx, func(ctx context.Context) error {
+		return db.QueryRowContext(ctx, "SELECT VERSION(), @@lower_case_table_names, @@skip_name_resolve").
+			Scan(&s.version, &s.lowerCaseTableNames, &skip)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.mariadb = strings.Contains(strings.ToLower(s.version), "mariadb")
+	s.skipNameResolve = skip.String == "1" || strings.EqualFold(skip.String, "ON")
+
+	plugins, err := rt.queryStrings(ctx, db, "SELECT PLUGIN_NAME FROM information_schema.PLUGINS WHERE PLUGIN_TYPE = 'AUTHENTICATION' AND PLUGIN_STATUS = 'ACTIVE'")
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range plugins {
+		s.plugins[p] = true
+	}
+
+	err = rt.do(ctx, func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, "SHOW PRIVILEGES")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name, scope, comment sql.NullString
+			if err := rows.Scan(&name, &scope, &comment); err != nil {
+				return err
+			}
+			s.privileges[strings.ToUpper(name.String)] = true
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// userLimits returns the longest user and host names the server accepts.
+func (s *targetServer) userLimits() (user, host int) {
+	if s.mariadb {
+		return 80, 255
+	}
+	major, minor, patch := parseVersion(s.version)
+	user, host = 32, 60
+	if major < 5 || (major == 5 && minor < 7) {
+		user = 16
+	}
+	if major > 8 || (major == 8 && (minor > 0 || patch >= 17)) {
+		host = 255
+	}
+	return user, host
+}
+
+// parseVersion reads the leading major.minor.patch of a version string.
+func parseVersion(version string) (major, minor, patch int) {
+	fmt.Sscanf(version, "%d.%d.%d", &major, &minor, &patch)
+	return major, minor, patch
+}
+
+// objectLookup reports whether a database, table or routine exists on the target.
+type objectLookup func(ctx context.Context, obj GrantObject) (bool, error)
+
+// schemaLookup checks objects in information_schema, each query through rt.
+func schemaLookup(db *sql.DB, rt *retrier) objectLookup {
+	return func(ctx context.Context, obj GrantObject) (bool, error) {
+		var (
+			stmt string
+			args []any
+		)
+		switch {
+		case obj.Routine != "":
+			stmt = "SELECT COUNT(*) FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = ? AND ROUTINE_NAME = ? AND ROUTINE_TYPE = ?"
+			args = []any{obj.Database, obj.Table, obj.Routine}
+		case obj.Table == "*":
+			// Database-level grants match names as LIKE patterns.
+			stmt = "SELECT COUNT(*) FROM information_schema.SCHEMATA WHERE SCHEMA_NAME LIKE ?"
+			args = []any{obj.Database}
+		default:
+			stmt = "SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?"
+			args = []any{obj.Database, obj.Table}
+		}
+		var n int
+		err := rt.do(ctx, func(ctx context.Context) error {
+			return db.QueryRowContext(ctx, stmt, args...).Scan(&n)
+		})
+		if err != nil {
+			return false, err
+		}
+		return n > 0, nil
+	}
+}
+
+// preflight checks planned users against the target before anything is changed. Accounts
+// with error findings are marked so they are not applied.
+func (r *Runner) preflight(ctx context.Context, server *targetServer, lookup objectLookup, target config.Target, users []targetUser) []Finding {
+	var findings []Finding
+	report := func(severity string, id Identity, format string, args ...any) {
+		findings = append(findings, Finding{Severity: severity, Account: id.String(), Message: fmt.Sprintf(format, args...)})
+	}
+	maxUser, maxHost := server.userLimits()
+	missingObjects := make(map[GrantObject]bool)
+	folded := make(map[string]string)
+
+	for i := range users {
+		user := &users[i]
+		if user.Err != nil {
+			continue
+		}
+		id := Identity{User: user.User, Host: user.Host}
+		var problems []string
+		fail := func(format string, args ...any) {
+			msg := fmt.Sprintf(format, args...)
+			problems = append(problems, msg)
+			report(SeverityError, id, "%s", msg)
+		}
+
+		if n := len([]rune(user.User)); n > maxUser {
+			fail("user name has %d characters; %s allows %d", n, server.version, maxUser)
+		}
+		if n := len([]rune(user.Host)); n > maxHost {
+			fail("host name has %d characters; %s allows %d", n, server.version, maxHost)
+		}
+		if plugin := r.targetPlugin(target, user.UserRecord); plugin != "" && !server.plugins[plugin] {
+			fail("auth plugin %s is not active on the target", plugin)
+		}
+		if server.skipNameResolve && isHostname(user.Host) {
+			report(SeverityWarning, id, "host %s is a host name but skip_name_resolve is ON; the account cannot log in", user.Host)
+		}
+
+		kept := make([]string, 0, len(user.Grants))
+		for _, raw := range user.Grants {
+			g, err := ParseGrant(raw)
+			if err != nil || g.Kind != PrivilegeGrant {
+				kept = append(kept, raw)
+				continue
+			}
+			obj := g.ParseObject()
+			for _, p := range g.Privileges {
+				name, _ := splitPrivilege(canonicalPrivilege(p))
+				if name != "ALL PRIVILEGES" && name != "USAGE" && !server.privileges[name] {
+					fail("privilege %s is not supported by the target", name)
+				}
+			}
+			if obj.Database == "*" {
+				kept = append(kept, raw)
+				continue
+			}
+			if server.lowerCaseTableNames != 0 {
+				// The target folds database and table names (routine names are never case
+				// sensitive), so grants and lookups use the folded names.
+				name := canonicalObject(obj)
+				obj.Database = strings.ToLower(obj.Database)
+				if obj.Routine == "" {
+					obj.Table = strings.ToLower(obj.Table)
+				}
+				lower := canonicalObject(obj)
+				if lower != name {
+					report(SeverityWarning, id, "grant on %s matches %s on the target (lower_case_table_names=%d)", name, lower, server.lowerCaseTableNames)
+				}
+				if prev, ok := folded[lower]; ok && prev != name {
+					report(SeverityWarning, id, "grants on %s and %s refer to the same object on the target", prev, name)
+				}
+				folded[lower] = name
+			}
+			missing, checked := missingObjects[obj]
+			if !checked {
+				ok, err := lookup(ctx, obj)
+				if err != nil {
+					report(SeverityWarning, id, "could not check %s: %v", canonicalObject(obj), err)
+				}
+				missing = err == nil && !ok
+				missingObjects[obj] = missing
+			}
+			if !missing {
+				kept = append(kept, raw)
+				continue
+			}
+
+			object := canonicalObject(obj)
+			dbLevel := obj.Table == "*" && obj.Routine == ""
+			switch policy := r.missingObjects(target); {
+			case policy == config.MissingObjectsSkipGrant:
+				report(SeverityWarning, id, "%s does not exist on the target; grant skipped", object)
+				user.Skipped = append(user.Skipped, GrantResult{Grant: reportGrant(raw), Status: "skipped", Note: object + " does not exist"})
+				continue
+			case dbLevel && policy == config.MissingObjectsCreateSchema && !strings.Contains(obj.Database, "%"):
+				schema := unescapeWildcards(obj.Database)
+				report(SeverityWarning, id, "%s does not exist on the target; it will be created empty", quoteName(schema))
+				if user.GrantNotes == nil {
+					user.GrantNotes = make(map[string]string)
+				}
+				user.GrantNotes[raw] = "created empty database " + quoteName(schema)
+				user.Schemas = append(user.Schemas, schema)
+			case dbLevel:
+				report(SeverityWarning, id, "no database on the target matches %s", object)
+			default:
+				fail("%s does not exist on the target", object)
+			}
+			kept = append(kept, raw)
+		}
+		user.Grants = kept
+		if len(problems) > 0 {
+			user.Err = fmt.Errorf("preflight: %s", strings.Join(problems, "; "))
+		}
+	}
+	return findings
+}
+
+// targetPlugin is the auth plugin the account will use on the target, or "" for the server
+// default.
+func (r *Runner) targetPlugin(target config.Target, user UserRecord) string {
+	if mode := r.authMode(target); mode == config.AuthModeRotate || mode == config.AuthModeSecrets || user.SecretRef != "" {
+		plugin, _ := passwordPlugin(target, user)
+		return plugin
+	}
+	if user.AuthString == "" {
+		return ""
+	}
+	return user.Plugin
+}
+
+// isHostname reports whether an account host needs name resolution to match.
+func isHostname(host string) bool {
+	switch host {
+	case "", "%", "localhost":
+		return false
+	}
+	if _, _, ok := hostNetwork(host); ok {
+		return false
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return false
+	}
+	return strings.IndexFunc(host, unicode.IsLetter) >= 0
+}
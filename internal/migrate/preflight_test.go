@@ -0,0 +1,105 @@
+package migrate
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+)
+
+func TestPreflight(t *testing.T) {
+	server := &targetServer{
+		version:             "5.6.51-log",
+		lowerCaseTableNames: 1,
+		skipNameResolve:     true,
+		plugins:             map[string]bool{"mysql_native_password": true},
+		privileges:          map[string]bool{"SELECT": true, "INSERT": true, "EXECUTE": true, "PROCESS": true},
+	}
+	present := map[GrantObject]bool{
+		{Database: "shop", Table: "orders"}: true,
+		{Database: "shop", Table: "*"}:      true,
+	}
+	lookup := func(_ context.Context, obj GrantObject) (bool, error) {
+		return present[obj], nil
+	}
+	users := []targetUser{
+		{UserRecord: UserRecord{User: "app", Host: "app1.example.com", Plugin: "mysql_native_password", AuthString: "*AB", Grants: []string{
+			"GRANT SELECT, INSERT ON `shop`.`orders` TO `app`@`app1.example.com`",
+			"GRANT INSERT ON `shop`.* TO `app`@`app1.example.com`",
+			"GRANT SELECT ON `Shop`.* TO `app`@`app1.example.com`",
+		}}},
+		{UserRecord: UserRecord{User: "reporting_service_ro", Host: "10.0.%", Plugin: "caching_sha2_password", AuthString: "$A$005$x", Grants: []string{
+			"GRANT BACKUP_ADMIN ON *.* TO `reporting_service_ro`@`10.0.%`",
+			"GRANT EXECUTE ON PROCEDURE `shop`.`refund` TO `reporting_service_ro`@`10.0.%`",
+			"GRANT SELECT ON `archive`.* TO `reporting_service_ro`@`10.0.%`",
+		}}},
+	}
+
+	r := &Runner{}
+	findings := r.preflight(context.Background(), server, lookup, config.Target{}, users)
+	want := []Finding{
+		{SeverityWarning, "app@app1.example.com", "host app1.example.com is a host name but skip_name_resolve is ON; the account cannot log in"},
+		{SeverityWarning, "app@app1.example.com", "grant on `Shop`.* matches `shop`.* on the target (lower_case_table_names=1)"},
+		{SeverityWarning, "app@app1.example.com", "grants on `shop`.* and `Shop`.* refer to the same object on the target"},
+		{SeverityError, "reporting_service_ro@10.0.%", "user name has 20 characters; 5.6.51-log allows 16"},
+		{SeverityError, "reporting_service_ro@10.0.%", "auth plugin caching_sha2_password is not active on the target"},
+		{SeverityError, "reporting_service_ro@10.0.%", "privilege BACKUP_ADMIN is not supported by the target"},
+		{SeverityError, "reporting_service_ro@10.0.%", "PROCEDURE `shop`.`refund` does not exist on the target"},
+		{SeverityWarning, "reporting_service_ro@10.0.%", "no database on the target matches `archive`.*"},
+	}
+	if !reflect.DeepEqual(findings, want) {
+		t.Fatalf("findings =\n%+v\nwant\n%+v", findings, want)
+	}
+	if users[0].Err != nil {
+		t.Fatalf("warnings should not block app: %v", users[0].Err)
+	}
+	if users[1].Err == nil || !strings.Contains(users[1].Err.Error(), "BACKUP_ADMIN") {
+		t.Fatalf("errors should block reporting_service_ro: %v", users[1].Err)
+	}
+}
+
+func TestIsHostname(t *testing.T) {
+	for host, want := range map[string]bool{
+		"%": false, "localhost": false, "10.0.%": false, "10.0.0.0/255.255.0.0": false, "::1": false,
+		"db1.example.com": true, "%.example.com": true,
+	} {
+		if got := isHostname(host); got != want {
+			t.Errorf("isHostname(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestPreflightMissingObjectPolicy(t *testing.T) {
+	server := &targetServer{version: "8.0.36", privileges: map[string]bool{"SELECT": true}}
+	lookup := func(_ context.Context, obj GrantObject) (bool, error) { return false, nil }
+	grants := []string{
+		"GRANT USAGE ON *.* TO `app`@`%`",
+		"GRANT SELECT ON `app\\_db`.* TO `app`@`%`",
+		"GRANT SELECT ON `shop`.`orders` TO `app`@`%`",
+	}
+
+	tests := []struct {
+		policy  string
+		grants  []string
+		schemas []string
+		skipped int
+		failed  bool
+	}{
+		{config.MissingObjectsFail, grants, nil, 0, true},
+		{config.MissingObjectsSkipGrant, grants[:1], nil, 2, false},
+		{config.MissingObjectsCreateSchema, grants, []string{"app_db"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			users := []targetUser{{UserRecord: UserRecord{User: "app", Host: "%", Grants: grants}}}
+			r := &Runner{MissingObjects: tt.policy}
+			r.preflight(context.Background(), server, lookup, config.Target{}, users)
+			u := users[0]
+			if !reflect.DeepEqual(u.Grants, tt.grants) || !reflect.DeepEqual(u.Schemas, tt.schemas) || len(u.Skipped) != tt.skipped || (u.Err != nil) != tt.failed {
+				t.Fatalf("grants=%q schemas=%q skipped=%+v err=%v", u.Grants, u.Schemas, u.Skipped, u.Err)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package migrate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+)
+
+// adminPrivileges are the static privileges removed by the ADMIN drop alias. Dynamic privileges
+// ending in _ADMIN and SYSTEM_USER are treated as administrative as well.
+var adminPrivileges = map[string]bool{
+	"ALL PRIVILEGES":     true,
+	"CREATE USER":        true,
+	"CREATE TABLESPACE":  true,
+	"FILE":               true,
+	"GRANT OPTION":       true,
+	"PROCESS":            true,
+	"RELOAD":             true,
+	"REPLICATION CLIENT": true,
+	"REPLICATION SLAVE":  true,
+	"SHUTDOWN":           true,
+	"SUPER":              true,
+	"SYSTEM_USER":        true,
+}
+
+func isAdminPrivilege(name string) bool {
+	return adminPrivileges[name] || strings.HasSuffix(name, "_ADMIN")
+}
+
+// privilegeRule is a compiled config.PrivilegeRule.
+type privilegeRule struct {
+	name              string
+	mapping           map[string][]string
+	drop              map[string]bool
+	dropAdmin         bool
+	removeGrantOption bool
+}
+
+// compilePrivilegeRules returns the rules that apply to target, in configuration order.
+func compilePrivilegeRules(rules []config.PrivilegeRule, target config.Target) []privilegeRule {
+	var out []privilegeRule
+	for idx, rule := range rules {
+		if !ruleMatchesTarget(rule, target) {
+			continue
+		}
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule-%d", idx+1)
+		}
+		pr := privilegeRule{
+			name:              name,
+			mapping:           make(map[string][]string, len(rule.Map)),
+			drop:              make(map[string]bool, len(rule.Drop)),
+			removeGrantOption: rule.RemoveGrantOption,
+		}
+		for from, to := range rule.Map {
+			var repl []string
+			for _, p := range strings.Split(to, ",") {
+				if p = strings.TrimSpace(p); p != "" {
+					repl = append(repl, canonicalPrivilege(p))
+				}
+			}
+			pr.mapping[canonicalPrivilege(from)] = repl
+		}
+		for _, d := range rule.Drop {
+			d = canonicalPrivilege(d)
+			switch d {
+			case "ADMIN":
+				pr.dropAdmin = true
+			case "GRANT OPTION":
+				pr.removeGrantOption = true
+			default:
+				pr.drop[d] = true
+			}
+		}
+		out = append(out, pr)
+	}
+	return out
+}
+
+func ruleMatchesTarget(rule config.PrivilegeRule, target config.Target) bool {
+	if len(rule.Targets) == 0 {
+		return true
+	}
+	for _, t := range rule.Targets {
+		if t == target.Name || (target.Group != "" && t == target.Group) {
+			return true
+		}
+	}
+	return false
+}
+
+// transformGrants applies rules to privilege grants and returns the rewritten statements with a
+// note for every change. Grants left without privileges are dropped. Partial revokes get the
+// same rules as the grants they restrict, so a renamed privilege stays revoked.
+func transformGrants(grants []string, rules []privilegeRule) ([]string, []string, error) {
+	if len(rules) == 0 {
+		return grants, nil, nil
+	}
+	var (
+		out   []string
+		notes []string
+	)
+	for _, raw := range grants {
+		g, err := parseStatement(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		if g.Kind != PrivilegeGrant {
+			out = append(out, raw)
+			continue
+		}
+		kind := "grant"
+		if g.Revoke {
+			kind = "revoke"
+		}
+		changed := false
+		for _, rule := range rules {
+			var ruleNotes []string
+			g, ruleNotes = rule.apply(g)
+			for _, n := range ruleNotes {
+				if g.Revoke {
+					n += " (revoke)"
+				}
+				notes = append(notes, fmt.Sprintf("%s: %s on %s", rule.name, n, g.Object))
+			}
+			changed = changed || len(ruleNotes) > 0
+		}
+		switch {
+		case !changed:
+			out = append(out, raw)
+		case len(g.Privileges) == 0:
+			notes = append(notes, fmt.Sprintf("dropped %s on %s", kind, g.Object))
+		default:
+			out = append(out, g.String())
+		}
+	}
+	return out, notes, nil
+}
+
+func (r privilegeRule) apply(g Grant) (Grant, []string) {
+	var notes []string
+	privs := make([]string, 0, len(g.Privileges))
+	seen := make(map[string]bool, len(g.Privileges))
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			privs = append(privs, p)
+		}
+	}
+	for _, priv := range g.Privileges {
+		name, cols := splitPrivilege(priv)
+		if repl, ok := r.mapping[name]; ok {
+			notes = append(notes, fmt.Sprintf("%s -> %s", name, strings.Join(repl, ", ")))
+			for _, p := range repl {
+				if !r.drop[p] && !(r.dropAdmin && isAdminPrivilege(p)) {
+					add(p + cols)
+				}
+			}
+			continue
+		}
+		if r.drop[name] || (r.dropAdmin && isAdminPrivilege(name)) {
+			notes = append(notes, "dropped "+name)
+			continue
+		}
+		add(priv)
+	}
+	g.Privileges = privs
+	if g.GrantOption && (r.removeGrantOption || r.dropAdmin) {
+		g.GrantOption = false
+		notes = append(notes, "removed grant option")
+	}
+	return g, notes
+}
+
+// splitPrivilege separates a privilege name from its column list (" (`a`, `b`)").
+func splitPrivilege(priv string) (string, string) {
+	if idx := strings.Index(priv, " ("); idx >= 0 {
+		return priv[:idx], priv[idx:]
+	}
+	return priv, ""
+}
+
+// canonicalPrivilege upper-cases a privilege name and folds the ALL alias.
+func canonicalPrivilege(p string) string {
+	p = normalizePrivilege(p)
+	if p == "ALL" {
+		return "ALL PRIVILEGES"
+	}
+	return p
+}
@@ -0,0 +1,126 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+)
+
+// Retry defaults for zero config values.
+const (
+	defaultRetryAttempts = 3
+	defaultRetryDelay    = 200 * time.Millisecond
+	defaultRetryMaxDelay = 5 * time.Second
+)
+
+// isTransient reports whether err may clear by itself, so the same statement or connection
+// can succeed when tried again. Everything else (syntax, privileges, missing objects) is
+// permanent.
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	switch mysqlErrorNumber(err) {
+	case 1040, // too many connections
+		1205, // lock wait timeout
+		1213, // deadlock
+		2006, // server has gone away
+		2013: // lost connection during query
+		return true
+	}
+	// Dials that time out while a server fails over. Unknown hosts and refused connections
+	// are configuration errors and fail at once.
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retrier runs operations again after transient errors, with exponential backoff and full
+// jitter, and counts the retries. Each attempt gets timeout, when set.
+type retrier struct {
+	policy  config.Retry
+	timeout time.Duration
+	retries int
+}
+
+func newRetrier(policy config.Retry, timeout time.Duration) *retrier {
+	if policy.Attempts == 0 {
+		policy.Attempts = defaultRetryAttempts
+	}
+	if policy.InitialDelay == 0 {
+		policy.InitialDelay = config.Duration(defaultRetryDelay)
+	}
+	if policy.MaxDelay == 0 {
+		policy.MaxDelay = config.Duration(defaultRetryMaxDelay)
+	}
+	return &retrier{policy: policy, timeout: timeout}
+}
+
+// do runs op until it succeeds, fails permanently, runs out of attempts or ctx is done.
+func (r *retrier) do(ctx context.Context, op func(ctx context.Context) error) error {
+	delay := min(time.Duration(r.policy.InitialDelay), time.Duration(r.policy.MaxDelay))
+	for attempt := 1; ; attempt++ {
+		err := r.attempt(ctx, op)
+		if err == nil || attempt >= r.policy.Attempts || !isTransient(err) {
+			return err
+		}
+		timer := time.NewTimer(time.Duration(rand.Int63n(int64(delay))) + 1)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		r.retries++
+		delay = min(2*delay, time.Duration(r.policy.MaxDelay))
+	}
+}
+
+func (r *retrier) attempt(ctx context.Context, op func(ctx context.Context) error) error {
+	if r.timeout <= 0 {
+		return op(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return op(ctx)
+}
+
+// retryExec retries statements through a retrier. Every statement the runner issues is
+// idempotent (IF EXISTS / IF NOT EXISTS, GRANT, ALTER USER), so one that was lost with its
+// connection can be sent again.
+type retryExec struct {
+	exec execer
+	*retrier
+}
+
+func (e retryExec) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	var res sql.Result
+	err := e.do(ctx, func(ctx context.Context) (err error) {
+		res, err = e.exec.ExecContext(ctx, query, args...)
+		return err
+	})
+	return res, err
+}
+
+// queryStrings reads a single-column result through the retrier, each attempt within its
+// timeout.
+func (r *retrier) queryStrings(ctx context.Context, db querier, stmt string) (out []string, err error) {
+	err = r.do(ctx, func(ctx context.Context) (err error) {
+		out, err = queryStrings(ctx, db, stmt)
+		return err
+	})
+	return out, err
+}
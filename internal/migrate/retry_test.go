@@ -0,0 +1,83 @@
+package migrate
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"deadlock", &mysql.MySQLError{Number: 1213}, true},
+		{"lock wait timeout", fmt.Errorf("grant: %w", &mysql.MySQLError{Number: 1205}), true},
+		{"too many connections", &mysql.MySQLError{Number: 1040}, true},
+		{"bad connection", driver.ErrBadConn, true},
+		{"invalid connection", mysql.ErrInvalidConn, true},
+		{"access denied", &mysql.MySQLError{Number: 1045}, false},
+		{"no such table", &mysql.MySQLError{Number: 1146}, false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"dial timeout", &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}, true},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, false},
+		{"unknown host", &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "db.invalid", IsNotFound: true}}, false},
+		{"other", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(tt.err); got != tt.want {
+				t.Fatalf("isTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateTargetRetriesDeadlocks(t *testing.T) {
+	users := []UserRecord{{User: "app", Host: "%", RawIdentity: "app@%", Grants: []string{
+		"GRANT SELECT ON `shop`.* TO 'app'@'%'",
+	}}}
+	retry := config.Retry{Attempts: 3, InitialDelay: config.Duration(1), MaxDelay: config.Duration(1)}
+
+	tests := []struct {
+		name        string
+		deadlocks   int
+		wantStatus  string
+		wantRetries int
+		wantGrants  int
+	}{
+		{"recovers", 2, "applied", 2, 1},
+		{"gives up", 3, "partial", 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, ft := newFakeTarget(t, "t1")
+			ft.deadlocks["GRANT SELECT"] = tt.deadlocks
+			r := &Runner{Retry: retry}
+			res := r.migrateTarget(context.Background(), &runState{users: users}, target)
+			u := res.Users[0]
+			if u.Status != tt.wantStatus || u.Retries != tt.wantRetries {
+				t.Fatalf("user = %+v", u)
+			}
+			grants := 0
+			for _, stmt := range ft.executed() {
+				if strings.HasPrefix(stmt, "GRANT SELECT") {
+					grants++
+				}
+			}
+			if grants != tt.wantGrants {
+				t.Fatalf("executed %d grants, want %d", grants, tt.wantGrants)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package migrate
 import (
 	"context"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
@@ -13,17 +14,35 @@ import (
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/raojinlin/mysql-user-migrate/internal/config"
+	"github.com/raojinlin/mysql-user-migrate/internal/credential"
+	"github.com/raojinlin/mysql-user-migrate/internal/crypt"
 
 	_ "github.com/go-sql-driver/mysql" // register MySQL driver
 )
 
 // Runner orchestrates migrations across targets.
 type Runner struct {
+	Source         Source // defaults to a MySQLSource for SourceDSN
 	SourceDSN      string
 	Targets        []config.Target
 	Include        []string
 	Exclude        []string
+	UserMap        []config.UserMapping
+	PrivilegeRules []config.PrivilegeRule
+	Credentials    config.Credentials
+	Sink           credential.Sink     // receives generated passwords in rotate mode
+	Secrets        *credential.Secrets // supplies cleartext passwords in secrets mode
+	ScriptDir      string              // write per-target SQL scripts instead of executing
+	Key            *crypt.Key          // seals plan files when set
+	MissingObjects string              // policy for grants on missing objects; targets may override it
+	OnPartial      string              // keep or roll back accounts where some grants failed
+	BackupDir      string              // write per-target undo scripts before changing accounts
+	RollbackOnFail bool                // run the undo statements when a target has failed accounts
+	Journal        *Journal            // records outcomes and skips accounts an earlier run completed
+	Retry          config.Retry        // retries after transient errors
+	Timeouts       config.Timeouts     // targets may override all but Run
 	DryRun         bool
+	Precheck       bool // block targets where the connection lacks privileges the plan needs
 	DropMissing    bool
 	ForceOverwrite bool
 	Concurrency    int
@@ -39,97 +58,368 @@ func (r *Runner) Run(ctx context.Context) (*Report, error) {
 		r.Logger = log.New(log.Writer(), "", log.LstdFlags)
 	}
 
-	srcDB, err := openDB(ctx, r.SourceDSN)
+	if r.Timeouts.Run > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(r.Timeouts.Run))
+		defer cancel()
+	}
+
+	source := r.source()
+	sourceUsers, err := r.loadSource(ctx, source)
 	if err != nil {
-		return nil, fmt.Errorf("connect source: %w", err)
+		return nil, err
 	}
-	defer srcDB.Close()
+	r.Logger.Printf("loaded %d users from %s", len(sourceUsers), source.Describe())
 
-	sourceUsers, err := r.loadSourceUsers(ctx, srcDB)
+	userRules, err := compileUserMap(r.UserMap)
 	if err != nil {
-		return nil, fmt.Errorf("load source users: %w", err)
+		return nil, err
+	}
+
+	run := &runState{
+		source:    source.Describe(),
+		users:     sourceUsers,
+		userRules: userRules,
 	}
-	r.Logger.Printf("loaded %d users from source", len(sourceUsers))
 
 	report := &Report{
-		Source:    MaskDSN(r.SourceDSN),
+		Source:    run.source,
 		DryRun:    r.DryRun,
 		StartedAt: time.Now(),
 	}
 
 	sem := make(chan struct{}, r.Concurrency)
 	var wg sync.WaitGroup
-	results := make(chan TargetReport, len(r.Targets))
+	// Each target writes its own slot so the report follows config order, not completion order.
+	results := make([]TargetReport, len(r.Targets))
 
-	for _, target := range r.Targets {
+	for i, target := range r.Targets {
 		wg.Add(1)
-		go func(t config.Target) {
+		go func(i int, t config.Target) {
 			defer wg.Done()
 			sem <- struct{}{}
 			defer func() { <-sem }()
-			results <- r.migrateTarget(ctx, sourceUsers, t)
-		}(target)
+			results[i] = r.migrateTarget(ctx, run, t)
+		}(i, target)
 	}
 
 	wg.Wait()
-	close(results)
 
-	for res := range results {
+	for _, res := range results {
 		report.Targets = append(report.Targets, res)
-		report.TotalFailed += res.Failed
+		report.TotalFailed += res.Failed + res.RolledBack
 		report.TotalUsers += len(res.Users)
+		// A run cancelled after its last account finished is complete.
+		if res.Cancelled > 0 {
+			report.Cancelled = true
+		}
 	}
+	report.TimedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
 	report.FinishedAt = time.Now()
 	return report, nil
 }
 
-func (r *Runner) migrateTarget(ctx context.Context, users []UserRecord, target config.Target) TargetReport {
-	start := time.Now()
-	targetName := target.Name
-	if targetName == "" {
-		targetName = MaskDSN(target.DSN)
+func (r *Runner) source() Source {
+	if r.Source != nil {
+		return r.Source
 	}
+	return &MySQLSource{DSN: r.SourceDSN, ConnectTimeout: time.Duration(r.Timeouts.Connect)}
+}
+
+// loadSource loads the accounts that pass the include/exclude filters.
+func (r *Runner) loadSource(ctx context.Context, source Source) ([]UserRecord, error) {
+	users, err := source.Load(ctx, func(user, host string) bool {
+		return ShouldInclude(user, host, r.Include, r.Exclude)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load source users: %w", err)
+	}
+	if len(users) == 0 {
+		return nil, errors.New("no users matched include/exclude filters")
+	}
+	return users, nil
+}
+
+// runState is shared by every target of a run.
+type runState struct {
+	source    string // source description for reports and scripts
+	users     []UserRecord
+	userRules []userRule
+}
+
+func (r *Runner) migrateTarget(ctx context.Context, run *runState, target config.Target) TargetReport {
+	start := time.Now()
 	result := TargetReport{
-		Target:    targetName,
+		Target:    targetLabel(target),
 		DryRun:    r.DryRun,
 		StartedAt: start,
 	}
 
-	db, err := openDB(ctx, target.DSN)
+	planned, err := r.prepareTarget(run.users, run.userRules, target)
+	if err != nil {
+		result.Error = err.Error()
+		result.Failed = len(run.users)
+		result.FinishedAt = time.Now()
+		result.DurationMS = result.FinishedAt.Sub(result.StartedAt).Milliseconds()
+		return result
+	}
+
+	// Accounts an earlier run completed are reported from its journal and not touched again.
+	if r.Journal != nil {
+		pending := planned[:0]
+		for _, user := range planned {
+			if prev, ok := r.Journal.Completed(result.Target, user.User, user.Host); ok {
+				prev.Resumed = true
+				result.Users = append(result.Users, prev)
+				result.count(prev.Status)
+				continue
+			}
+			pending = append(pending, user)
+		}
+		planned = pending
+		if len(planned) == 0 {
+			result.FinishedAt = time.Now()
+			result.DurationMS = result.FinishedAt.Sub(result.StartedAt).Milliseconds()
+			return result
+		}
+	}
+
+	if ctx.Err() != nil {
+		cancelUsers(&result, planned, stopStatus(ctx))
+		result.FinishedAt = time.Now()
+		result.DurationMS = result.FinishedAt.Sub(result.StartedAt).Milliseconds()
+		return result
+	}
+	// Once a target has started, work in flight finishes when the run is cancelled or its
+	// deadline passes: both are checked between accounts, so no account is left half-changed.
+	// Only the target's own timeout interrupts statements.
+	timeouts := r.timeouts(target)
+	work := context.WithoutCancel(ctx)
+	if timeouts.Target > 0 {
+		var cancel context.CancelFunc
+		work, cancel = context.WithTimeout(work, time.Duration(timeouts.Target))
+		defer cancel()
+	}
+	noteTimeout := func() {
+		if work.Err() != nil {
+			result.Timeout = fmt.Sprintf("target did not finish within %s", time.Duration(timeouts.Target))
+		}
+	}
+	// Connecting, the checks and the backup come before any account is changed, so the run
+	// deadline interrupts them.
+	prep := work
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		prep, cancel = context.WithDeadline(work, deadline)
+		defer cancel()
+	}
+	// failAll reports the accounts of a target that could not be prepared, as timed-out or
+	// cancelled when the run stopped meanwhile.
+	failAll := func() {
+		switch {
+		case prep.Err() != nil && work.Err() == nil:
+			// Only the run deadline ends prep early; its timer may fire before ctx's.
+			cancelUsers(&result, planned, "timed-out")
+		case ctx.Err() != nil:
+			cancelUsers(&result, planned, stopStatus(ctx))
+		default:
+			result.Failed = len(planned)
+		}
+	}
+
+	var db *sql.DB
+	connect := newRetrier(r.Retry, 0)
+	err = connect.do(prep, func(ctx context.Context) (err error) {
+		db, err = openDB(ctx, target.DSN, time.Duration(timeouts.Connect))
+		return err
+	})
+	result.Retries = connect.retries
 	if err != nil {
 		result.Error = fmt.Sprintf("connect target: %v", err)
-		result.Failed = len(users)
+		failAll()
+		noteTimeout()
 		result.FinishedAt = time.Now()
 		result.DurationMS = result.FinishedAt.Sub(result.StartedAt).Milliseconds()
 		return result
 	}
 	defer db.Close()
 
-	for _, user := range users {
-		userResult := r.applyUser(ctx, db, user)
+	// Reads before the accounts are changed get the statement timeout and retries too; their
+	// retries are reported with the connection's.
+	reads := newRetrier(r.Retry, time.Duration(timeouts.Statement))
+
+	server, err := loadTargetServer(prep, db, reads)
+	if err != nil {
+		result.Findings = []Finding{{Severity: SeverityWarning, Message: fmt.Sprintf("pre-flight checks skipped: %v", err)}}
+	} else {
+		result.Findings = r.preflight(prep, server, schemaLookup(db, reads), target, planned)
+	}
+
+	if r.Precheck {
+		missing, err := precheckTarget(prep, db, reads, planned, r.backsUp())
+		if err != nil || len(missing) > 0 {
+			result.Precheck = missing
+			result.Error = fmt.Sprintf("precheck failed: %d missing privileges", len(missing))
+			if err != nil {
+				result.Error = fmt.Sprintf("precheck: %v", err)
+			}
+			failAll()
+			result.Retries += reads.retries
+			noteTimeout()
+			result.FinishedAt = time.Now()
+			result.DurationMS = result.FinishedAt.Sub(result.StartedAt).Milliseconds()
+			return result
+		}
+	}
+
+	var backup *targetBackup
+	if r.backsUp() {
+		if backup, err = backupTarget(prep, db, reads, planned); err == nil && r.BackupDir != "" {
+			result.Undo, err = r.writeUndo(backup, target)
+		}
+		if err != nil {
+			// Nothing is changed without a way back.
+			result.Error = fmt.Sprintf("backup: %v", err)
+			failAll()
+			result.Retries += reads.retries
+			noteTimeout()
+			result.FinishedAt = time.Now()
+			result.DurationMS = result.FinishedAt.Sub(result.StartedAt).Milliseconds()
+			return result
+		}
+	}
+
+	// In script mode statements are recorded instead of executed; reads still go to the target
+	// so the script matches what a live run would do.
+	var exec execer = db
+	var script *sqlScript
+	if r.ScriptDir != "" {
+		script = &sqlScript{}
+		exec = script
+	}
+
+	// Only accounts that were applied and failed trigger the target rollback; accounts blocked
+	// before applying changed nothing.
+	applyFailed := false
+	for i, user := range planned {
+		if ctx.Err() != nil {
+			cancelUsers(&result, planned[i:], stopStatus(ctx))
+			break
+		}
+		if work.Err() != nil {
+			cancelUsers(&result, planned[i:], "timed-out")
+			break
+		}
+		var userResult UserResult
+		if user.Err != nil {
+			userResult = UserResult{User: user.User, Host: user.Host, Status: "error", Error: user.Err.Error()}
+		} else {
+			rt := newRetrier(r.Retry, time.Duration(timeouts.Statement))
+			userResult = r.applyUser(work, db, retryExec{exec, rt}, rt, target, user)
+			userResult.Retries = rt.retries
+			applyFailed = applyFailed || userResult.Status == "error" || userResult.Status == "partial"
+		}
 		result.Users = append(result.Users, userResult)
-		switch userResult.Status {
-		case "applied", "planned":
-			result.Applied++
-		case "skipped":
-			result.Skipped++
-		case "error":
-			result.Failed++
-		default:
-			result.Failed++
+		result.count(userResult.Status)
+		r.journal(&result, userResult)
+	}
+
+	if r.RollbackOnFail && backup != nil && applyFailed {
+		// The undo statements run even when the target ran out of time, each within the
+		// statement timeout and without retries.
+		undo := retryExec{db, newRetrier(config.Retry{Attempts: 1}, time.Duration(timeouts.Statement))}
+		rollbackTarget(context.WithoutCancel(work), undo, backup, &result)
+		for _, u := range result.Users {
+			if u.Status == "rolled-back" && !u.Resumed {
+				r.journal(&result, u)
+			}
+		}
+	}
+
+	if script != nil {
+		path, err := r.writeScript(script, run.source, target)
+		if err != nil {
+			result.Error = err.Error()
 		}
+		result.Script = path
 	}
 
+	result.Retries += reads.retries
+	noteTimeout()
 	result.FinishedAt = time.Now()
 	result.DurationMS = result.FinishedAt.Sub(result.StartedAt).Milliseconds()
 	return result
 }
 
-func (r *Runner) applyUser(ctx context.Context, db *sql.DB, user UserRecord) UserResult {
+// cancelUsers reports users that were not started because the run was cancelled or ran out
+// of time.
+func cancelUsers(result *TargetReport, users []targetUser, status string) {
+	for _, user := range users {
+		result.Users = append(result.Users, UserResult{User: user.User, Host: user.Host, Status: status})
+		result.count(status)
+	}
+}
+
+// stopStatus is the status of users not started once ctx is done.
+func stopStatus(ctx context.Context) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "timed-out"
+	}
+	return "cancelled"
+}
+
+// timeouts resolves the timeouts for a target.
+func (r *Runner) timeouts(target config.Target) config.Timeouts {
+	return r.Timeouts.Override(target.Timeouts)
+}
+
+// journal records an account outcome. A journal that cannot be written is reported once per
+// target; the run itself goes on.
+func (r *Runner) journal(result *TargetReport, user UserResult) {
+	if err := r.Journal.Record(result.Target, user); err != nil {
+		for _, f := range result.Findings {
+			if f.Message == "journal: "+err.Error() {
+				return
+			}
+		}
+		result.Findings = append(result.Findings, Finding{Severity: SeverityWarning, Message: "journal: " + err.Error()})
+	}
+}
+
+// applyUser changes one account. Statements go through exec; rt retries the reads as well.
+func (r *Runner) applyUser(ctx context.Context, db *sql.DB, exec execer, rt *retrier, target config.Target, user targetUser) UserResult {
 	identity := fmt.Sprintf("%s@%s", user.User, user.Host)
-	out := UserResult{User: user.User, Host: user.Host}
+	out := UserResult{User: user.User, Host: user.Host, Transforms: user.Transforms, Grants: user.Skipped}
+	if identity != user.RawIdentity {
+		out.Source = user.RawIdentity
+	}
+	mode := r.authMode(target)
+	hasPassword := user.AuthString != "" || user.SecretRef != ""
+	if hasPassword {
+		out.Auth = "copied"
+		if mode == config.AuthModeRotate {
+			out.Auth = "rotated"
+		}
+	}
 
-	exists, err := userExists(ctx, db, user.User, user.Host)
+	var password *passwordAuth
+	// Desired-state accounts that reference a secret use it unless passwords are rotated.
+	// Accounts without a password, such as roles, are not looked up.
+	if hasPassword && (mode == config.AuthModeSecrets || (user.SecretRef != "" && mode == config.AuthModeCopy)) {
+		var err error
+		password, out.Auth, err = r.secretPassword(ctx, target, user)
+		if err != nil {
+			out.Status = "error"
+			out.Error = fmt.Sprintf("secret %s: %v", identity, err)
+			return out
+		}
+	}
+
+	var exists bool
+	err := rt.do(ctx, func(ctx context.Context) (err error) {
+		exists, err = userExists(ctx, db, user.User, user.Host)
+		return err
+	})
 	if err != nil {
 		out.Status = "error"
 		out.Error = fmt.Sprintf("check exists: %v", err)
@@ -137,16 +427,27 @@ func (r *Runner) applyUser(ctx context.Context, db *sql.DB, user UserRecord) Use
 	}
 
 	if r.DryRun {
-		if exists && !(r.DropMissing || r.ForceOverwrite) {
-			out.Status = "planned"
-		} else {
-			out.Status = "planned"
+		for _, grant := range user.Grants {
+			if note := user.GrantNotes[grant]; note != "" {
+				out.Grants = append(out.Grants, GrantResult{Grant: reportGrant(grant), Status: "planned", Note: note})
+			}
 		}
+		out.Status = "planned"
 		return out
 	}
 
+	if out.Auth == "rotated" {
+		password, err = r.rotatePassword(ctx, target, user.UserRecord)
+		if err != nil {
+			out.Status = "error"
+			out.Error = fmt.Sprintf("rotate %s: %v", identity, err)
+			return out
+		}
+	}
+
+	existed := exists
 	if exists && (r.DropMissing || r.ForceOverwrite) {
-		if err := dropUser(ctx, db, user); err != nil {
+		if err := dropUser(ctx, exec, user.UserRecord); err != nil {
 			out.Status = "error"
 			out.Error = fmt.Sprintf("drop %s: %v", identity, err)
 			return out
@@ -155,86 +456,87 @@ func (r *Runner) applyUser(ctx context.Context, db *sql.DB, user UserRecord) Use
 	}
 
 	if !exists {
-		if err := createUser(ctx, db, user); err != nil {
+		if err := createUser(ctx, exec, user.UserRecord, password); err != nil {
 			out.Status = "error"
 			out.Error = fmt.Sprintf("create %s: %v", identity, err)
 			return out
 		}
+	} else if password != nil {
+		if err := alterPassword(ctx, exec, user.UserRecord, *password); err != nil {
+			out.Status = "error"
+			out.Error = fmt.Sprintf("set password %s: %v", identity, err)
+			return out
+		}
 	}
 
-	for _, grant := range user.Grants {
-		if err := applyGrant(ctx, db, grant); err != nil {
+	for _, schema := range user.Schemas {
+		if _, err := exec.ExecContext(ctx, "CREATE DATABASE IF NOT EXISTS "+quoteName(schema)); err != nil {
 			out.Status = "error"
-			out.Error = fmt.Sprintf("grant %s: %v", identity, err)
+			out.Error = fmt.Sprintf("create database %s: %v", schema, err)
 			return out
 		}
 	}
 
-	out.Status = "applied"
-	return out
-}
-
-func (r *Runner) loadSourceUsers(ctx context.Context, db *sql.DB) ([]UserRecord, error) {
-	rows, err := db.QueryContext(ctx, `SELECT user, host, plugin, authentication_string FROM mysql.user`)
-	if err != nil {
-		return nil, err
+	// Every grant is attempted so one failure does not hide the others.
+	applied := "applied"
+	if r.ScriptDir != "" {
+		applied = "scripted"
 	}
-	defer rows.Close()
-
-	var users []UserRecord
-	for rows.Next() {
-		var user, host, plugin, auth string
-		if err := rows.Scan(&user, &host, &plugin, &auth); err != nil {
-			return nil, err
-		}
-
-		if !ShouldInclude(user, host, r.Include, r.Exclude) {
-			continue
+	failed := 0
+	for _, grant := range user.Grants {
+		res := GrantResult{Grant: reportGrant(grant), Status: applied, Note: user.GrantNotes[grant]}
+		if err := ctx.Err(); err != nil {
+			// The target ran out of time: the remaining grants are not sent.
+			res.Status, res.Error = "error", fmt.Sprintf("not applied: %v", err)
+			failed++
+		} else if err := applyGrant(ctx, exec, grant); err != nil {
+			res.Status, res.Error, res.ErrorNumber = "error", err.Error(), mysqlErrorNumber(err)
+			// Objects dropped after the pre-flight checks, and columns, are only found here.
+			if isMissingObject(err) && r.missingObjects(target) == config.MissingObjectsSkipGrant {
+				res.Status = "skipped"
+			} else {
+				failed++
+			}
 		}
+		out.Grants = append(out.Grants, res)
+	}
 
-		grants, err := fetchGrants(ctx, db, user, host)
-		if err != nil {
-			return nil, fmt.Errorf("grants for %s@%s: %w", user, host, err)
+	out.Status = applied
+	if failed > 0 {
+		out.Status = "partial"
+		out.Error = fmt.Sprintf("%d of %d grants failed", failed, len(user.Grants))
+		if r.OnPartial == config.OnPartialRollback {
+			// Like the target rollback, this runs after a timeout; exec bounds each statement.
+			r.rollbackPartial(context.WithoutCancel(ctx), exec, user, existed, &out)
 		}
-
-		users = append(users, UserRecord{
-			User:        user,
-			Host:        host,
-			Plugin:      plugin,
-			AuthString:  auth,
-			Grants:      grants,
-			RawIdentity: fmt.Sprintf("%s@%s", user, host),
-		})
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	if len(users) == 0 {
-		return nil, errors.New("no users matched include/exclude filters")
-	}
-	return users, nil
+	return out
 }
 
-func fetchGrants(ctx context.Context, db *sql.DB, user, host string) ([]string, error) {
-	// MySQL does not permit parameter placeholders in SHOW GRANTS.
-	stmt := fmt.Sprintf("SHOW GRANTS FOR '%s'@'%s'", escape(user), escape(host))
-	rows, err := db.QueryContext(ctx, stmt)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var grants []string
-	for rows.Next() {
-		var grant string
-		if err := rows.Scan(&grant); err != nil {
-			return nil, err
+// rollbackPartial drops a partially configured account that this run created, or restores
+// its backup. Accounts that existed before the run are otherwise kept, since dropping them
+// would lose more than the run added.
+func (r *Runner) rollbackPartial(ctx context.Context, exec execer, user targetUser, existed bool, out *UserResult) {
+	var stmts []string
+	switch {
+	case user.Backup != nil:
+		stmts = user.Backup.restore()
+	case existed:
+		out.Error += "; kept because the account existed before the run and no backup was taken"
+		return
+	default:
+		stmts = []string{"DROP USER IF EXISTS " + Identity{User: user.User, Host: user.Host}.Quoted()}
+	}
+	for _, stmt := range stmts {
+		if _, err := exec.ExecContext(ctx, stmt); err != nil {
+			out.Error += fmt.Sprintf("; rollback failed: %v", err)
+			return
 		}
-		grants = append(grants, grant)
 	}
-	return grants, rows.Err()
+	out.Status = "rolled-back"
 }
 
-func userExists(ctx context.Context, db *sql.DB, user, host string) (bool, error) {
+func userExists(ctx context.Context, db querier, user, host string) (bool, error) {
 	var count int
 	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM mysql.user WHERE user=? AND host=?", user, host).Scan(&count); err != nil {
 		return false, err
@@ -242,28 +544,91 @@ func userExists(ctx context.Context, db *sql.DB, user, host string) (bool, error
 	return count > 0, nil
 }
 
-func dropUser(ctx context.Context, db *sql.DB, user UserRecord) error {
+func dropUser(ctx context.Context, db execer, user UserRecord) error {
 	stmt := fmt.Sprintf("DROP USER IF EXISTS '%s'@'%s'", escape(user.User), escape(user.Host))
 	_, err := db.ExecContext(ctx, stmt)
 	return err
 }
 
-func createUser(ctx context.Context, db *sql.DB, user UserRecord) error {
+func createUser(ctx context.Context, db execer, user UserRecord, password *passwordAuth) error {
 	stmt := fmt.Sprintf("CREATE USER IF NOT EXISTS '%s'@'%s'", escape(user.User), escape(user.Host))
-	if user.Plugin != "" && user.AuthString != "" {
-		stmt = fmt.Sprintf("%s IDENTIFIED WITH '%s' AS '%s'", stmt, escape(user.Plugin), escape(user.AuthString))
+	if password != nil {
+		stmt = fmt.Sprintf("%s %s", stmt, password.clause())
+	} else if user.Plugin != "" && user.AuthString != "" {
+		stmt = fmt.Sprintf("%s IDENTIFIED WITH '%s' AS %s", stmt, escape(user.Plugin), authLiteral(user.AuthString))
 	} else if user.AuthString != "" {
 		stmt = fmt.Sprintf("%s IDENTIFIED BY PASSWORD '%s'", stmt, escape(user.AuthString))
+	} else if user.Role {
+		// Like CREATE ROLE: a role without a password must not be usable as a login.
+		stmt += " ACCOUNT LOCK"
 	}
 	_, err := db.ExecContext(ctx, stmt)
 	return err
 }
 
-func applyGrant(ctx context.Context, db *sql.DB, grant string) error {
+// authLiteral quotes an auth string. Binary hashes (caching_sha2_password) are written as a
+// hex literal so they survive scripts and client character sets.
+func authLiteral(auth string) string {
+	if isPrintable(auth) {
+		return "'" + escape(auth) + "'"
+	}
+	return "0x" + hex.EncodeToString([]byte(auth))
+}
+
+func alterPassword(ctx context.Context, db execer, user UserRecord, password passwordAuth) error {
+	stmt := fmt.Sprintf("ALTER USER '%s'@'%s' %s", escape(user.User), escape(user.Host), password.clause())
+	_, err := db.ExecContext(ctx, stmt)
+	return err
+}
+
+func applyGrant(ctx context.Context, db execer, grant string) error {
 	_, err := db.ExecContext(ctx, grant)
 	return err
 }
 
+// backsUp reports whether accounts are backed up before a target is changed.
+func (r *Runner) backsUp() bool {
+	return !r.DryRun && (r.BackupDir != "" || r.RollbackOnFail)
+}
+
+// missingObjects resolves the missing-object policy for a target.
+func (r *Runner) missingObjects(target config.Target) string {
+	if target.MissingObjects != "" {
+		return target.MissingObjects
+	}
+	if r.MissingObjects != "" {
+		return r.MissingObjects
+	}
+	return config.MissingObjectsFail
+}
+
+// isMissingObject reports whether err says a database, table, column or routine does not
+// exist.
+func isMissingObject(err error) bool {
+	switch mysqlErrorNumber(err) {
+	case 1049, 1054, 1146, 1305: // bad database, bad field, no such table, routine does not exist
+		return true
+	}
+	return false
+}
+
+// mysqlErrorNumber returns the server error number of err, or 0.
+func mysqlErrorNumber(err error) uint16 {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number
+	}
+	return 0
+}
+
+// reportGrant shortens a grant for reports, leaving out 5.6 IDENTIFIED BY PASSWORD clauses.
+func reportGrant(stmt string) string {
+	if idx := indexKeyword(stmt, "IDENTIFIED"); idx >= 0 {
+		return strings.TrimSpace(stmt[:idx])
+	}
+	return stmt
+}
+
 func escape(value string) string {
 	value = strings.ReplaceAll(value, `\`, `\\`)
 	value = strings.ReplaceAll(value, `'`, `''`)
@@ -289,12 +654,21 @@ func ShouldInclude(user, host string, include, exclude []string) bool {
 	return false
 }
 
-func openDB(ctx context.Context, dsn string) (*sql.DB, error) {
-	db, err := sql.Open("mysql", dsn)
+// driverName is the database/sql driver used for targets; tests substitute their own.
+var driverName = "mysql"
+
+// defaultConnectTimeout bounds a connection attempt when no connect timeout is configured.
+const defaultConnectTimeout = 5 * time.Second
+
+func openDB(ctx context.Context, dsn string, timeout time.Duration) (*sql.DB, error) {
+	if timeout <= 0 {
+		timeout = defaultConnectTimeout
+	}
+	db, err := sql.Open(driverName, dsn)
 	if err != nil {
 		return nil, err
 	}
-	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	pingCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	if err := db.PingContext(pingCtx); err != nil {
 		db.Close()
@@ -1,6 +1,19 @@
 package migrate
 
-import "testing"
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+	"github.com/raojinlin/mysql-user-migrate/internal/credential"
+)
 
 func TestMatchIdentity(t *testing.T) {
 	tests := []struct {
@@ -72,3 +85,410 @@ func TestMaskDSN(t *testing.T) {
 		})
 	}
 }
+
+func TestMigrateTargetPartialGrants(t *testing.T) {
+	users := []UserRecord{{User: "app", Host: "%", RawIdentity: "app@%", Grants: []string{
+		"GRANT USAGE ON *.* TO 'app'@'%'",
+		"GRANT SELECT ON `shop`.`orders` TO 'app'@'%'",
+		"GRANT SELECT ON `shop`.* TO 'app'@'%'",
+	}}}
+
+	tests := []struct {
+		name       string
+		onPartial  string
+		existing   bool
+		backup     bool
+		wantStatus string
+		wantDrop   bool
+	}{
+		{"keep", config.OnPartialKeep, false, false, "partial", false},
+		{"rollback created account", config.OnPartialRollback, false, false, "rolled-back", true},
+		{"rollback keeps existing account", config.OnPartialRollback, true, false, "partial", false},
+		{"rollback restores existing account", config.OnPartialRollback, true, true, "rolled-back", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var accounts []Identity
+			if tt.existing {
+				accounts = append(accounts, Identity{User: "app", Host: "%"})
+			}
+			target, ft := newFakeTarget(t, "t1", accounts...)
+			ft.failures["ON `shop`.`orders`"] = 1142
+
+			r := &Runner{OnPartial: tt.onPartial}
+			if tt.backup {
+				r.BackupDir = t.TempDir()
+			}
+			res := r.migrateTarget(context.Background(), &runState{users: users}, target)
+			if len(res.Users) != 1 || res.Failed+res.RolledBack != 1 || (res.RolledBack == 1) != (tt.wantStatus == "rolled-back") {
+				t.Fatalf("report = %+v", res)
+			}
+			u := res.Users[0]
+			if u.Status != tt.wantStatus || !strings.HasPrefix(u.Error, "1 of 3 grants failed") {
+				t.Fatalf("user = %+v", u)
+			}
+			statuses := make([]string, len(u.Grants))
+			for i, g := range u.Grants {
+				statuses[i] = g.Status
+			}
+			if strings.Join(statuses, ",") != "applied,error,applied" || u.Grants[1].ErrorNumber != 1142 {
+				t.Fatalf("grants = %+v", u.Grants)
+			}
+			dropped := strings.Contains(strings.Join(ft.executed(), "\n"), "DROP USER")
+			if dropped != tt.wantDrop {
+				t.Fatalf("dropped = %v, executed:\n%s", dropped, strings.Join(ft.executed(), "\n"))
+			}
+		})
+	}
+}
+
+func TestMigrateTargetLocksRoles(t *testing.T) {
+	users := []UserRecord{
+		{User: "reader", Host: "%", RawIdentity: "reader@%", Role: true, Grants: []string{"GRANT SELECT ON `shop`.* TO 'reader'@'%'"}},
+		{User: "app", Host: "%", RawIdentity: "app@%", Grants: []string{"GRANT 'reader'@'%' TO 'app'@'%'"}},
+	}
+	target, ft := newFakeTarget(t, "t1")
+	res := (&Runner{}).migrateTarget(context.Background(), &runState{users: users}, target)
+	if res.Applied != 2 {
+		t.Fatalf("report = %+v", res)
+	}
+	executed := strings.Join(ft.executed(), "\n")
+	if !strings.Contains(executed, "CREATE USER IF NOT EXISTS 'reader'@'%' ACCOUNT LOCK\n") ||
+		!strings.Contains(executed, "CREATE USER IF NOT EXISTS 'app'@'%'\n") {
+		t.Fatalf("executed:\n%s", executed)
+	}
+}
+
+func TestMigrateTargetSecretsSkipPasswordless(t *testing.T) {
+	users := []UserRecord{{User: "reader", Host: "%", RawIdentity: "reader@%", Role: true}}
+	secrets, err := credential.LoadSecrets(config.SecretsSource{Command: "echo called >&2; exit 1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, _ := newFakeTarget(t, "t1")
+
+	// The failing command shows the role was not looked up.
+	r := &Runner{Secrets: secrets, Credentials: config.Credentials{Mode: config.AuthModeSecrets}}
+	res := r.migrateTarget(context.Background(), &runState{users: users}, target)
+	if res.Applied != 1 || res.Users[0].Auth != "" {
+		t.Fatalf("report = %+v", res)
+	}
+}
+
+func TestMigrateTargetRollbackOnFailure(t *testing.T) {
+	app := Identity{User: "app", Host: "%"}
+	users := []UserRecord{
+		{User: "app", Host: "%", RawIdentity: "app@%", Grants: []string{"GRANT USAGE ON *.* TO 'app'@'%'"}},
+		{User: "new", Host: "%", RawIdentity: "new@%", Grants: []string{"GRANT USAGE ON *.* TO 'new'@'%'"}},
+	}
+	target, ft := newFakeTarget(t, "t1", app)
+	// The replacement of app fails after it was dropped.
+	ft.failures["CREATE USER IF NOT EXISTS 'app'"] = 1396
+
+	r := &Runner{BackupDir: t.TempDir(), RollbackOnFail: true, ForceOverwrite: true}
+	res := r.migrateTarget(context.Background(), &runState{source: "test", users: users}, target)
+	if !strings.HasPrefix(res.Rollback, "restored 2 accounts") {
+		t.Fatalf("rollback = %q, error = %q", res.Rollback, res.Error)
+	}
+	if res.Users[0].Status != "error" || res.Users[1].Status != "rolled-back" || res.Applied != 0 || res.Failed != 1 || res.RolledBack != 1 {
+		t.Fatalf("report = %+v", res)
+	}
+	if !ft.accounts[app] || ft.accounts[Identity{User: "new", Host: "%"}] {
+		t.Fatalf("accounts after rollback = %v", ft.accounts)
+	}
+
+	undo, err := os.ReadFile(res.Undo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"-- drop accounts\nDROP USER IF EXISTS 'app'@'%';\nDROP USER IF EXISTS 'new'@'%';\n",
+		"-- create accounts\nCREATE USER 'app'@'%' IDENTIFIED WITH 'mysql_native_password' AS '*2470C0C06DEE42FD1618BB99005ADCA2EC9D1E19';\n",
+		"-- grant privileges\nGRANT USAGE ON *.* TO 'app'@'%';\n",
+	}
+	for _, w := range want {
+		if !strings.Contains(string(undo), w) {
+			t.Fatalf("undo script missing %q:\n%s", w, undo)
+		}
+	}
+}
+
+func TestMigrateTargetRollbackOnFailureIgnoresRolledBack(t *testing.T) {
+	users := []UserRecord{
+		{User: "app", Host: "%", RawIdentity: "app@%", Grants: []string{"GRANT SELECT ON `shop`.`orders` TO 'app'@'%'"}},
+		{User: "new", Host: "%", RawIdentity: "new@%", Grants: []string{"GRANT USAGE ON *.* TO 'new'@'%'"}},
+	}
+	target, ft := newFakeTarget(t, "t1")
+	ft.failures["ON `shop`.`orders`"] = 1142
+
+	// The partial account is rolled back on its own; the rest of the target stays.
+	r := &Runner{RollbackOnFail: true, OnPartial: config.OnPartialRollback}
+	res := r.migrateTarget(context.Background(), &runState{users: users}, target)
+	if res.Rollback != "" || res.Applied != 1 || res.RolledBack != 1 || res.Failed != 0 {
+		t.Fatalf("report = %+v", res)
+	}
+	if !ft.accounts[Identity{User: "new", Host: "%"}] {
+		t.Fatalf("accounts = %v", ft.accounts)
+	}
+}
+
+func TestMigrateTargetRollbackOnFailureIgnoresBlocked(t *testing.T) {
+	users := []UserRecord{
+		{User: "app", Host: "%", RawIdentity: "app@%"},
+		{User: "legacy", Host: "%", RawIdentity: "legacy@%"},
+		{User: "new", Host: "%", RawIdentity: "new@%"},
+	}
+	rules, err := compileUserMap([]config.UserMapping{{From: "legacy", To: "app"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, ft := newFakeTarget(t, "t1")
+
+	// legacy collides with app and is never applied, so the target is kept.
+	r := &Runner{RollbackOnFail: true}
+	res := r.migrateTarget(context.Background(), &runState{users: users, userRules: rules}, target)
+	if res.Rollback != "" || res.Failed == 0 || res.RolledBack != 0 {
+		t.Fatalf("report = %+v", res)
+	}
+	if !ft.accounts[Identity{User: "new", Host: "%"}] {
+		t.Fatalf("accounts = %v", ft.accounts)
+	}
+}
+
+func TestMigrateTargetCancelled(t *testing.T) {
+	users := []UserRecord{
+		{User: "app", Host: "%", RawIdentity: "app@%", Grants: []string{"GRANT USAGE ON *.* TO 'app'@'%'"}},
+		{User: "new", Host: "%", RawIdentity: "new@%", Grants: []string{"GRANT USAGE ON *.* TO 'new'@'%'"}},
+	}
+	tests := []struct {
+		name        string
+		cancelAfter string // statement after which the run is cancelled; "" cancels before the target starts
+		want        string
+	}{
+		{"before the target", "", "cancelled,cancelled"},
+		// The account in flight finishes its remaining statements.
+		{"during an account", "CREATE USER IF NOT EXISTS 'app'", "applied,cancelled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			target, ft := newFakeTarget(t, "t1")
+			if tt.cancelAfter == "" {
+				cancel()
+			}
+			ft.onExec = func(stmt string) {
+				if strings.HasPrefix(stmt, tt.cancelAfter) {
+					cancel()
+				}
+			}
+
+			res := (&Runner{}).migrateTarget(ctx, &runState{users: users}, target)
+			statuses := make([]string, len(res.Users))
+			for i, u := range res.Users {
+				statuses[i] = u.Status
+			}
+			if strings.Join(statuses, ",") != tt.want || res.Failed != 0 {
+				t.Fatalf("report = %+v", res)
+			}
+			if executed := strings.Join(ft.executed(), "\n"); strings.Contains(executed, "'new'") {
+				t.Fatalf("cancelled account was changed:\n%s", executed)
+			}
+		})
+	}
+}
+
+// staticSource serves fixed accounts.
+type staticSource []UserRecord
+
+func (s staticSource) Load(context.Context, func(user, host string) bool) ([]UserRecord, error) {
+	return s, nil
+}
+func (s staticSource) Describe() string      { return "static" }
+func (s staticSource) ServerVersion() string { return "" }
+
+func TestRunCancelled(t *testing.T) {
+	users := staticSource{
+		{User: "app", Host: "%", RawIdentity: "app@%", Grants: []string{"GRANT USAGE ON *.* TO 'app'@'%'"}},
+		{User: "new", Host: "%", RawIdentity: "new@%", Grants: []string{"GRANT USAGE ON *.* TO 'new'@'%'"}},
+	}
+	tests := []struct {
+		name        string
+		cancelAfter string
+		want        bool
+	}{
+		{"accounts left", "GRANT USAGE ON *.* TO 'app'", true},
+		// A signal after the last account changes nothing, so the run is complete.
+		{"after the last account", "GRANT USAGE ON *.* TO 'new'", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			target, ft := newFakeTarget(t, "t1")
+			ft.onExec = func(stmt string) {
+				if strings.HasPrefix(stmt, tt.cancelAfter) {
+					cancel()
+				}
+			}
+
+			r := &Runner{Source: users, Targets: []config.Target{target}, Concurrency: 1, Logger: log.New(io.Discard, "", 0)}
+			report, err := r.Run(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Cancelled != tt.want {
+				t.Fatalf("cancelled = %v, targets = %+v", report.Cancelled, report.Targets)
+			}
+		})
+	}
+}
+
+func TestMigrateTargetTimeouts(t *testing.T) {
+	users := []UserRecord{
+		{User: "app", Host: "%", RawIdentity: "app@%", Grants: []string{"GRANT SELECT ON `shop`.* TO 'app'@'%'"}},
+		{User: "new", Host: "%", RawIdentity: "new@%", Grants: []string{"GRANT USAGE ON *.* TO 'new'@'%'"}},
+	}
+	ms := func(n int) config.Duration { return config.Duration(time.Duration(n) * time.Millisecond) }
+
+	tests := []struct {
+		name        string
+		runner      config.Timeouts
+		target      config.Timeouts
+		runDeadline bool
+		want        string
+		wantTimeout bool
+	}{
+		{"statement", config.Timeouts{Statement: ms(20)}, config.Timeouts{}, false, "partial,applied", false},
+		{"target", config.Timeouts{}, config.Timeouts{Target: ms(50)}, false, "partial,timed-out", true},
+		{"target overrides runner", config.Timeouts{Target: ms(60000)}, config.Timeouts{Target: ms(50)}, false, "partial,timed-out", true},
+		{"run deadline", config.Timeouts{}, config.Timeouts{}, true, "timed-out,timed-out", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.runDeadline {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, 0)
+				defer cancel()
+			}
+			target, ft := newFakeTarget(t, "t1")
+			target.Timeouts = tt.target
+			ft.stalls["GRANT SELECT"] = true
+
+			r := &Runner{Timeouts: tt.runner}
+			res := r.migrateTarget(ctx, &runState{users: users}, target)
+			statuses := make([]string, len(res.Users))
+			for i, u := range res.Users {
+				statuses[i] = u.Status
+			}
+			if strings.Join(statuses, ",") != tt.want || (res.Timeout != "") != tt.wantTimeout {
+				t.Fatalf("report = %+v", res)
+			}
+		})
+	}
+}
+
+func TestMigrateTargetReadTimeout(t *testing.T) {
+	users := []UserRecord{{User: "app", Host: "%", RawIdentity: "app@%", Grants: []string{"GRANT USAGE ON *.* TO 'app'@'%'"}}}
+	target, ft := newFakeTarget(t, "t1", Identity{User: "app", Host: "%"})
+	target.Timeouts = config.Timeouts{Statement: config.Duration(20 * time.Millisecond)}
+	ft.stalls["SHOW CREATE USER"] = true
+
+	r := &Runner{BackupDir: t.TempDir()}
+	res := r.migrateTarget(context.Background(), &runState{users: users}, target)
+	if !strings.HasPrefix(res.Error, "backup: ") || !strings.Contains(res.Error, "deadline exceeded") || res.Failed != 1 {
+		t.Fatalf("report = %+v", res)
+	}
+	if executed := strings.Join(ft.executed(), "\n"); strings.Contains(executed, "USER") || strings.Contains(executed, "GRANT") {
+		t.Fatalf("account changed after a failed backup:\n%s", executed)
+	}
+}
+
+func TestMigrateTargetRunDeadlineStopsBackup(t *testing.T) {
+	users := []UserRecord{{User: "app", Host: "%", RawIdentity: "app@%", Grants: []string{"GRANT USAGE ON *.* TO 'app'@'%'"}}}
+	target, ft := newFakeTarget(t, "t1", Identity{User: "app", Host: "%"})
+	ft.stalls["SHOW CREATE USER"] = true
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	r := &Runner{BackupDir: t.TempDir()}
+	res := r.migrateTarget(ctx, &runState{users: users}, target)
+	if !strings.HasPrefix(res.Error, "backup: ") || len(res.Users) != 1 || res.Users[0].Status != "timed-out" {
+		t.Fatalf("report = %+v", res)
+	}
+}
+
+func TestMigrateTargetTimeoutStopsGrants(t *testing.T) {
+	users := []UserRecord{{User: "app", Host: "%", RawIdentity: "app@%", Grants: []string{
+		"GRANT SELECT ON `shop`.* TO 'app'@'%'",
+		"GRANT INSERT ON `shop`.* TO 'app'@'%'",
+	}}}
+	target, ft := newFakeTarget(t, "t1")
+	target.Timeouts = config.Timeouts{Target: config.Duration(50 * time.Millisecond)}
+	ft.stalls["GRANT SELECT"] = true
+
+	r := &Runner{}
+	res := r.migrateTarget(context.Background(), &runState{users: users}, target)
+	if len(res.Users) != 1 || res.Users[0].Status != "partial" || res.Timeout == "" {
+		t.Fatalf("report = %+v", res)
+	}
+	if g := res.Users[0].Grants[1]; g.Status != "error" || !strings.HasPrefix(g.Error, "not applied: ") {
+		t.Fatalf("grant after the timeout = %+v", g)
+	}
+	if executed := strings.Join(ft.executed(), "\n"); strings.Contains(executed, "GRANT INSERT") {
+		t.Fatalf("grant sent after the target timed out:\n%s", executed)
+	}
+}
+
+// memorySink records credentials for tests.
+type memorySink struct {
+	mu    sync.Mutex
+	creds []credential.Credential
+}
+
+func (s *memorySink) Put(_ context.Context, cred credential.Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creds = append(s.creds, cred)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+func TestMigrateTargetRotateReportHasNoPasswords(t *testing.T) {
+	users := []UserRecord{
+		{User: "app", Host: "%", RawIdentity: "app@%", AuthString: "*HASH", Grants: []string{
+			"GRANT SELECT ON `shop`.* TO 'app'@'%'",
+			"GRANT SELECT ON `shop`.`orders` TO 'app'@'%'",
+		}},
+		{User: "web", Host: "%", RawIdentity: "web@%", AuthString: "*HASH"},
+	}
+	target, ft := newFakeTarget(t, "t1", Identity{User: "web", Host: "%"})
+	ft.failures["ON `shop`.`orders`"] = 1142
+	ft.failures["ALTER USER 'web'"] = 1396
+
+	sink := &memorySink{}
+	r := &Runner{Sink: sink, Credentials: config.Credentials{Mode: config.AuthModeRotate}}
+	res := r.migrateTarget(context.Background(), &runState{users: users}, target)
+	if len(sink.creds) != 2 || res.Failed != 2 {
+		t.Fatalf("report = %+v, %d credentials", res, len(sink.creds))
+	}
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := (&Report{Targets: []TargetReport{res}}).WriteJSON(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if executed := strings.Join(ft.executed(), "\n"); !strings.Contains(executed, sink.creds[0].Password) {
+		t.Fatalf("rotated password was not used:\n%s", executed)
+	}
+	for _, cred := range sink.creds {
+		if strings.Contains(string(data), cred.Password) {
+			t.Fatalf("report contains the password of %s:\n%s", cred.User, data)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+	"github.com/raojinlin/mysql-user-migrate/internal/crypt"
+)
+
+// execer runs statements that change a target. *sql.DB executes them; sqlScript records them.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Script phases, in the order statements must run: accounts are dropped and created before
+// privileges are granted, and roles exist before they are granted to other accounts.
+const (
+	phaseDrop = iota
+	phaseCreate
+	phaseAlter
+	phaseGrant
+	phaseRoleGrant
+	phaseRevoke
+	phaseCount
+)
+
+var phaseTitles = [phaseCount]string{
+	phaseDrop:      "drop accounts",
+	phaseCreate:    "create accounts",
+	phaseAlter:     "set passwords",
+	phaseGrant:     "grant privileges",
+	phaseRoleGrant: "grant roles and proxies",
+	phaseRevoke:    "revoke privileges",
+}
+
+// sqlScript records statements grouped by phase.
+type sqlScript struct {
+	phases [phaseCount][]string
+	count  int
+}
+
+// ExecContext records the statement. Statements are built without placeholders, so args are
+// not expected.
+func (s *sqlScript) ExecContext(_ context.Context, query string, args ...any) (sql.Result, error) {
+	if len(args) > 0 {
+		return nil, fmt.Errorf("script mode does not support statement arguments")
+	}
+	phase := statementPhase(query)
+	s.phases[phase] = append(s.phases[phase], query)
+	s.count++
+	return driverResult{}, nil
+}
+
+func statementPhase(stmt string) int {
+	upper := strings.ToUpper(strings.TrimSpace(stmt))
+	switch {
+	case strings.HasPrefix(upper, "DROP USER"):
+		return phaseDrop
+	case strings.HasPrefix(upper, "CREATE USER"):
+		return phaseCreate
+	case strings.HasPrefix(upper, "ALTER USER"):
+		return phaseAlter
+	case strings.HasPrefix(upper, "REVOKE"):
+		return phaseRevoke
+	}
+	if g, err := ParseGrant(stmt); err == nil && g.Kind != PrivilegeGrant {
+		return phaseRoleGrant
+	}
+	return phaseGrant
+}
+
+// render produces the script with a header describing source, target and time.
+func (s *sqlScript) render(source, target, dsn string, at time.Time) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- mysql-user-migrate SQL plan\n")
+	fmt.Fprintf(&b, "-- source: %s\n", source)
+	fmt.Fprintf(&b, "-- target: %s (%s)\n", target, MaskDSN(dsn))
+	fmt.Fprintf(&b, "-- generated: %s\n", at.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "-- statements: %d\n", s.count)
+	s.writeBody(&b)
+	return []byte(b.String())
+}
+
+// writeBody writes the statements phase by phase.
+func (s *sqlScript) writeBody(b *strings.Builder) {
+	for phase, stmts := range s.phases {
+		if len(stmts) == 0 {
+			continue
+		}
+		fmt.Fprintf(b, "\n-- %s\n", phaseTitles[phase])
+		for _, stmt := range stmts {
+			b.WriteString(strings.TrimSuffix(strings.TrimSpace(stmt), ";"))
+			b.WriteString(";\n")
+		}
+	}
+}
+
+// statements returns the recorded statements in execution order.
+func (s *sqlScript) statements() []string {
+	out := make([]string, 0, s.count)
+	for _, stmts := range s.phases {
+		out = append(out, stmts...)
+	}
+	return out
+}
+
+// writeScript writes the target's plan to ScriptDir, sealed when a key is configured.
+func (r *Runner) writeScript(script *sqlScript, source string, target config.Target) (string, error) {
+	label := targetLabel(target)
+	if err := os.MkdirAll(r.ScriptDir, 0o700); err != nil {
+		return "", fmt.Errorf("write script: %w", err)
+	}
+	name := fileSafe(label) + ".sql"
+	if r.Key != nil {
+		name += ".enc"
+	}
+	path := filepath.Join(r.ScriptDir, name)
+	if err := crypt.WriteFile(path, script.render(source, label, target.DSN, time.Now()), r.Key); err != nil {
+		return "", fmt.Errorf("write script: %w", err)
+	}
+	return path, nil
+}
+
+// fileSafe replaces characters that are not safe in file names.
+func fileSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', 0:
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+type driverResult struct{}
+
+func (driverResult) LastInsertId() (int64, error) { return 0, nil }
+func (driverResult) RowsAffected() (int64, error) { return 0, nil }
@@ -0,0 +1,45 @@
+package migrate
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSQLScriptOrder(t *testing.T) {
+	script := &sqlScript{}
+	ctx := context.Background()
+	for _, stmt := range []string{
+		"CREATE USER IF NOT EXISTS 'app'@'%'",
+		"GRANT 'reader'@'%' TO 'app'@'%'",
+		"GRANT SELECT ON `shop`.* TO 'app'@'%'",
+		"DROP USER IF EXISTS 'reader'@'%'",
+		"CREATE USER IF NOT EXISTS 'reader'@'%'",
+	} {
+		if _, err := script.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("ExecContext: %v", err)
+		}
+	}
+
+	out := string(script.render("src", "stg", "u:p@tcp(stg:3306)/", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	if strings.Contains(out, ":p@") {
+		t.Fatalf("script leaks DSN password:\n%s", out)
+	}
+	var stmts []string
+	for _, line := range strings.Split(out, "\n") {
+		if line != "" && !strings.HasPrefix(line, "--") {
+			stmts = append(stmts, line)
+		}
+	}
+	want := []string{
+		"DROP USER IF EXISTS 'reader'@'%';",
+		"CREATE USER IF NOT EXISTS 'app'@'%';",
+		"CREATE USER IF NOT EXISTS 'reader'@'%';",
+		"GRANT SELECT ON `shop`.* TO 'app'@'%';",
+		"GRANT 'reader'@'%' TO 'app'@'%';",
+	}
+	if strings.Join(stmts, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("statements =\n%s\nwant\n%s", strings.Join(stmts, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,165 @@
+package migrate
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/crypt"
+)
+
+// SnapshotVersion is the snapshot format written by Export.
+const SnapshotVersion = 1
+
+// HashMode controls how exports write password hashes.
+type HashMode string
+
+const (
+	// HashesInline writes hashes into the exported file.
+	HashesInline HashMode = "inline"
+	// HashesVariables references hashes as variables whose values are written separately.
+	HashesVariables HashMode = "variables"
+	// HashesOmit leaves hashes out.
+	HashesOmit HashMode = "omit"
+)
+
+// Snapshot is a portable, point-in-time copy of source accounts.
+type Snapshot struct {
+	Version       int               `json:"version" yaml:"version"`
+	CreatedAt     time.Time         `json:"created_at" yaml:"created_at"`
+	Source        string            `json:"source" yaml:"source"`
+	SourceVersion string            `json:"source_version" yaml:"source_version"`
+	Accounts      []SnapshotAccount `json:"accounts" yaml:"accounts"`
+}
+
+// SnapshotAccount is one account in a snapshot. Auth strings that are not printable text
+// (caching_sha2_password hashes) are stored hex-encoded in AuthStringHex.
+type SnapshotAccount struct {
+	User          string            `json:"user" yaml:"user"`
+	Host          string            `json:"host" yaml:"host"`
+	Plugin        string            `json:"plugin,omitempty" yaml:"plugin,omitempty"`
+	AuthString    string            `json:"auth_string,omitempty" yaml:"auth_string,omitempty"`
+	AuthStringHex string            `json:"auth_string_hex,omitempty" yaml:"auth_string_hex,omitempty"`
+	Role          bool              `json:"role,omitempty" yaml:"role,omitempty"`
+	DefaultRoles  []string          `json:"default_roles,omitempty" yaml:"default_roles,omitempty"`
+	Attributes    map[string]string `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+	Grants        []string          `json:"grants" yaml:"grants"`
+}
+
+// Export loads the filtered source accounts into a snapshot.
+func (r *Runner) Export(ctx context.Context) (*Snapshot, error) {
+	source := r.source()
+	users, err := r.loadSource(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &Snapshot{
+		Version:       SnapshotVersion,
+		CreatedAt:     time.Now().UTC(),
+		Source:        source.Describe(),
+		SourceVersion: source.ServerVersion(),
+	}
+	for _, u := range users {
+		snap.Accounts = append(snap.Accounts, snapshotAccount(u))
+	}
+	return snap, nil
+}
+
+// Users converts the snapshot back into source records.
+func (s *Snapshot) Users() ([]UserRecord, error) {
+	users := make([]UserRecord, 0, len(s.Accounts))
+	for _, a := range s.Accounts {
+		auth := a.AuthString
+		if a.AuthStringHex != "" {
+			b, err := hex.DecodeString(a.AuthStringHex)
+			if err != nil {
+				return nil, fmt.Errorf("%s@%s: auth_string_hex: %w", a.User, a.Host, err)
+			}
+			auth = string(b)
+		}
+		users = append(users, UserRecord{
+			User:         a.User,
+			Host:         a.Host,
+			Plugin:       a.Plugin,
+			AuthString:   auth,
+			Grants:       a.Grants,
+			RawIdentity:  a.User + "@" + a.Host,
+			Role:         a.Role,
+			DefaultRoles: a.DefaultRoles,
+			Attributes:   a.Attributes,
+		})
+	}
+	return users, nil
+}
+
+// RedactHashes removes password hashes, e.g. for snapshots shared for review.
+func (s *Snapshot) RedactHashes() {
+	for i := range s.Accounts {
+		s.Accounts[i].AuthString = ""
+		s.Accounts[i].AuthStringHex = ""
+	}
+}
+
+// Encode renders the snapshot as YAML for .yaml/.yml paths and as JSON otherwise.
+func (s *Snapshot) Encode(path string) ([]byte, error) {
+	if isYAMLPath(path) {
+		return yaml.Marshal(s)
+	}
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// WriteFile writes the snapshot to path. Snapshots hold password hashes, so the file is
+// created readable by the owner only and sealed when key is not nil.
+func (s *Snapshot) WriteFile(path string, key *crypt.Key) error {
+	data, err := s.Encode(path)
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	if err := crypt.WriteFile(path, data, key); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	return nil
+}
+
+func snapshotAccount(u UserRecord) SnapshotAccount {
+	a := SnapshotAccount{
+		User:         u.User,
+		Host:         u.Host,
+		Plugin:       u.Plugin,
+		Role:         u.Role,
+		DefaultRoles: u.DefaultRoles,
+		Attributes:   u.Attributes,
+		Grants:       u.Grants,
+	}
+	if isPrintable(u.AuthString) {
+		a.AuthString = u.AuthString
+	} else {
+		a.AuthStringHex = hex.EncodeToString([]byte(u.AuthString))
+	}
+	return a
+}
+
+func isPrintable(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isYAMLPath(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".yaml" || ext == ".yml"
+}
@@ -0,0 +1,97 @@
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/crypt"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	users := []UserRecord{
+		{User: "app", Host: "%", Plugin: "mysql_native_password", AuthString: "*ABCDEF", Grants: []string{"GRANT USAGE ON *.* TO `app`@`%`"}},
+		{User: "svc", Host: "10.0.%", Plugin: "caching_sha2_password", AuthString: "$A$005$\x01\x7f\xfe salt", DefaultRoles: []string{"r@%"}},
+	}
+	snap := &Snapshot{Version: SnapshotVersion}
+	for _, u := range users {
+		snap.Accounts = append(snap.Accounts, snapshotAccount(u))
+	}
+	if snap.Accounts[1].AuthString != "" || snap.Accounts[1].AuthStringHex == "" {
+		t.Fatalf("binary auth string should be hex encoded: %+v", snap.Accounts[1])
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Snapshot
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	got, err := decoded.Users()
+	if err != nil {
+		t.Fatalf("Users: %v", err)
+	}
+	for i := range users {
+		if got[i].AuthString != users[i].AuthString || got[i].RawIdentity != users[i].User+"@"+users[i].Host {
+			t.Errorf("account %d = %+v, want %+v", i, got[i], users[i])
+		}
+	}
+}
+
+func TestSnapshotSource(t *testing.T) {
+	for _, name := range []string{"snap.json", "snap.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			snap := &Snapshot{
+				Version:   SnapshotVersion,
+				CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+				Source:    "user:****@tcp(src:3306)/",
+				Accounts: []SnapshotAccount{
+					{User: "app", Host: "%", Grants: []string{"GRANT USAGE ON *.* TO `app`@`%`"}},
+					{User: "root", Host: "localhost"},
+				},
+			}
+			if err := snap.WriteFile(path, nil); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+
+			src := &SnapshotSource{Path: path}
+			users, err := src.Load(context.Background(), func(user, host string) bool {
+				return ShouldInclude(user, host, nil, []string{"root"})
+			})
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if len(users) != 1 || users[0].User != "app" {
+				t.Fatalf("users = %+v, want only app", users)
+			}
+			if !src.snap.CreatedAt.Equal(snap.CreatedAt) {
+				t.Fatalf("created_at = %v, want %v", src.snap.CreatedAt, snap.CreatedAt)
+			}
+		})
+	}
+}
+
+func TestEncryptedSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snap.json")
+	snap := &Snapshot{Version: SnapshotVersion, Accounts: []SnapshotAccount{{User: "app", Host: "%", AuthString: "*HASH"}}}
+	if err := snap.WriteFile(path, crypt.Passphrase("s3cret")); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	got, err := ReadSnapshot(path, crypt.Passphrase("s3cret"))
+	if err != nil || got.Accounts[0].AuthString != "*HASH" {
+		t.Fatalf("ReadSnapshot = %+v, %v", got, err)
+	}
+	if _, err := ReadSnapshot(path, nil); err == nil {
+		t.Fatalf("expected error reading encrypted snapshot without a key")
+	}
+	if _, err := ReadSnapshot(path, crypt.Passphrase("wrong")); !errors.Is(err, crypt.ErrTampered) {
+		t.Fatalf("ReadSnapshot(wrong key) error = %v, want ErrTampered", err)
+	}
+}
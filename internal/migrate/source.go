@@ -0,0 +1,271 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"gopkg.in/yaml.v3"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/crypt"
+)
+
+// Source supplies the accounts to migrate.
+type Source interface {
+	// Load returns the accounts for which match reports true.
+	Load(ctx context.Context, match func(user, host string) bool) ([]UserRecord, error)
+	// Describe identifies the source in logs and reports without exposing secrets.
+	Describe() string
+	// ServerVersion reports the source server version once loaded, if known.
+	ServerVersion() string
+}
+
+// MySQLSource reads accounts from a live MySQL server.
+type MySQLSource struct {
+	DSN            string
+	ConnectTimeout time.Duration // defaults to 5s
+	version        string
+}
+
+// Load connects to the server and reads the matching accounts and their grants.
+func (s *MySQLSource) Load(ctx context.Context, match func(user, host string) bool) ([]UserRecord, error) {
+	db, err := openDB(ctx, s.DSN, s.ConnectTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("connect source: %w", err)
+	}
+	defer db.Close()
+
+	if err := db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&s.version); err != nil {
+		return nil, fmt.Errorf("source version: %w", err)
+	}
+	return loadMySQLUsers(ctx, db, match)
+}
+
+// Describe returns the masked DSN.
+func (s *MySQLSource) Describe() string {
+	return MaskDSN(s.DSN)
+}
+
+// ServerVersion returns the version read by Load.
+func (s *MySQLSource) ServerVersion() string {
+	return s.version
+}
+
+// SnapshotSource reads accounts from a snapshot written by export. Key opens encrypted
+// snapshots.
+type SnapshotSource struct {
+	Path string
+	Key  *crypt.Key
+	snap *Snapshot
+}
+
+// Load reads the snapshot and returns the matching accounts.
+func (s *SnapshotSource) Load(_ context.Context, match func(user, host string) bool) ([]UserRecord, error) {
+	snap, err := ReadSnapshot(s.Path, s.Key)
+	if err != nil {
+		return nil, err
+	}
+	s.snap = snap
+	users, err := snap.Users()
+	if err != nil {
+		return nil, err
+	}
+	return filterUsers(users, match), nil
+}
+
+// Describe names the snapshot and the server it was taken from.
+func (s *SnapshotSource) Describe() string {
+	if s.snap == nil {
+		return "snapshot " + s.Path
+	}
+	return fmt.Sprintf("snapshot %s (%s at %s)", s.Path, s.snap.Source, s.snap.CreatedAt.Format("2006-01-02T15:04:05Z07:00"))
+}
+
+// ServerVersion returns the version recorded in the snapshot.
+func (s *SnapshotSource) ServerVersion() string {
+	if s.snap == nil {
+		return ""
+	}
+	return s.snap.SourceVersion
+}
+
+// ReadSnapshot reads and checks a snapshot file, decrypting it when it is sealed.
+func ReadSnapshot(path string, key *crypt.Key) (*Snapshot, error) {
+	data, err := crypt.ReadFile(path, key)
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot %s: %w", path, err)
+	}
+	// YAML is a superset of JSON, so one decoder handles both formats.
+	var snap Snapshot
+	if err := yaml.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("parse snapshot: %w", err)
+	}
+	if snap.Version < 1 || snap.Version > SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	return &snap, nil
+}
+
+func filterUsers(users []UserRecord, match func(user, host string) bool) []UserRecord {
+	out := users[:0]
+	for _, u := range users {
+		if match(u.User, u.Host) {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+func loadMySQLUsers(ctx context.Context, db *sql.DB, match func(user, host string) bool) ([]UserRecord, error) {
+	// SELECT * keeps the load working across layouts: 5.6 stores the hash in Password, later
+	// versions in authentication_string, and attribute columns vary by version.
+	rows, err := db.QueryContext(ctx, `SELECT * FROM mysql.user`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := loadRoleAccounts(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("role edges: %w", err)
+	}
+	defaults, err := loadDefaultRoles(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("default roles: %w", err)
+	}
+
+	var users []UserRecord
+	values := make([]sql.RawBytes, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(cols))
+		for i, col := range cols {
+			row[strings.ToLower(col)] = string(values[i])
+		}
+		user, host := row["user"], row["host"]
+
+		if !match(user, host) {
+			continue
+		}
+
+		grants, err := fetchGrants(ctx, db, user, host)
+		if err != nil {
+			return nil, fmt.Errorf("grants for %s@%s: %w", user, host, err)
+		}
+
+		auth := row["authentication_string"]
+		if auth == "" {
+			auth = row["password"]
+		}
+		identity := fmt.Sprintf("%s@%s", user, host)
+		users = append(users, UserRecord{
+			User:         user,
+			Host:         host,
+			Plugin:       row["plugin"],
+			AuthString:   auth,
+			Grants:       grants,
+			RawIdentity:  identity,
+			Role:         roles[identity],
+			DefaultRoles: defaults[identity],
+			Attributes:   userAttributes(row),
+		})
+	}
+	return users, rows.Err()
+}
+
+// attributeColumns are the mysql.user columns kept as account attributes, when present.
+var attributeColumns = []string{
+	"account_locked",
+	"password_expired",
+	"password_lifetime",
+	"password_reuse_history",
+	"password_reuse_time",
+	"password_require_current",
+	"ssl_type",
+	"max_questions",
+	"max_updates",
+	"max_connections",
+	"max_user_connections",
+	"user_attributes",
+}
+
+func userAttributes(row map[string]string) map[string]string {
+	attrs := make(map[string]string)
+	for _, col := range attributeColumns {
+		if v, ok := row[col]; ok && v != "" {
+			attrs[col] = v
+		}
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	return attrs
+}
+
+// loadRoleAccounts returns the accounts granted to others as roles. Servers without
+// mysql.role_edges (before 8.0) have no roles.
+func loadRoleAccounts(ctx context.Context, db *sql.DB) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT FROM_USER, FROM_HOST FROM mysql.role_edges`)
+	if isNoSuchTable(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := make(map[string]bool)
+	for rows.Next() {
+		var user, host string
+		if err := rows.Scan(&user, &host); err != nil {
+			return nil, err
+		}
+		roles[user+"@"+host] = true
+	}
+	return roles, rows.Err()
+}
+
+// loadDefaultRoles returns each account's default roles as user@host.
+func loadDefaultRoles(ctx context.Context, db *sql.DB) (map[string][]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT USER, HOST, DEFAULT_ROLE_USER, DEFAULT_ROLE_HOST FROM mysql.default_roles`)
+	if isNoSuchTable(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	defaults := make(map[string][]string)
+	for rows.Next() {
+		var user, host, roleUser, roleHost string
+		if err := rows.Scan(&user, &host, &roleUser, &roleHost); err != nil {
+			return nil, err
+		}
+		key := user + "@" + host
+		defaults[key] = append(defaults[key], roleUser+"@"+roleHost)
+	}
+	return defaults, rows.Err()
+}
+
+func isNoSuchTable(err error) bool {
+	var myErr *mysql.MySQLError
+	return errors.As(err, &myErr) && myErr.Number == 1146
+}
+
+func fetchGrants(ctx context.Context, db *sql.DB, user, host string) ([]string, error) {
+	// MySQL does not permit parameter placeholders in SHOW GRANTS.
+	return queryStrings(ctx, db, fmt.Sprintf("SHOW GRANTS FOR '%s'@'%s'", escape(user), escape(host)))
+}
@@ -0,0 +1,244 @@
+package migrate
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// splitStatements splits a SQL script into statements. Comments are removed, except that the
+// body of executable comments (/*!80001 ... */) is kept, and DELIMITER lines are honoured.
+func splitStatements(script string) []string {
+	var (
+		stmts     []string
+		cur       strings.Builder
+		delimiter = ";"
+		execDepth int
+	)
+	flush := func() {
+		if stmt := strings.TrimSpace(cur.String()); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+		cur.Reset()
+	}
+
+	for i := 0; i < len(script); {
+		c := script[i]
+		atLineStart := i == 0 || script[i-1] == '\n'
+		switch {
+		case atLineStart && hasPrefixFold(script[i:], "DELIMITER "):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			flush()
+			delimiter = strings.TrimSpace(script[i+len("DELIMITER ") : i+end])
+			i += end
+		case c == '\'' || c == '"' || c == '`':
+			end := quotedEnd(script, i)
+			cur.WriteString(script[i:end])
+			i = end
+		case c == '#' || (c == '-' && strings.HasPrefix(script[i:], "--") && (i+2 == len(script) || isSpace(script[i+2]))):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			i += end
+		case strings.HasPrefix(script[i:], "/*!"):
+			// Keep the body; skip the optional version number.
+			i += 3
+			for i < len(script) && script[i] >= '0' && script[i] <= '9' {
+				i++
+			}
+			execDepth++
+		case strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 4
+			}
+		case execDepth > 0 && strings.HasPrefix(script[i:], "*/"):
+			execDepth--
+			i += 2
+		case strings.HasPrefix(script[i:], delimiter):
+			flush()
+			i += len(delimiter)
+		default:
+			cur.WriteByte(c)
+			i++
+		}
+	}
+	flush()
+	return stmts
+}
+
+// quotedEnd returns the index just past the quoted string starting at i.
+func quotedEnd(s string, i int) int {
+	quote := s[i]
+	for j := i + 1; j < len(s); j++ {
+		switch {
+		case s[j] == '\\' && quote != '`':
+			j++
+		case s[j] == quote && j+1 < len(s) && s[j+1] == quote:
+			j++
+		case s[j] == quote:
+			return j + 1
+		}
+	}
+	return len(s)
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+type tokenKind int
+
+const (
+	tokWord   tokenKind = iota // keywords and bare identifiers
+	tokString                  // '...' or "..." literal, unescaped
+	tokIdent                   // `...` identifier, unescaped
+	tokHex                     // 0x... or X'...' literal, decoded
+	tokPunct                   // single punctuation character
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// is reports whether the token is the given keyword or punctuation.
+func (t token) is(s string) bool {
+	return (t.kind == tokWord || t.kind == tokPunct) && strings.EqualFold(t.text, s)
+}
+
+// tokenize splits a single statement into tokens.
+func tokenize(stmt string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(stmt); {
+		c := stmt[i]
+		switch {
+		case isSpace(c):
+			i++
+		case c == '\'' || c == '"' || c == '`':
+			end := quotedEnd(stmt, i)
+			if end > len(stmt) || stmt[end-1] != c || end == i+1 {
+				return nil, fmt.Errorf("unterminated quote at %d", i)
+			}
+			value, _, err := readName(stmt[i:end])
+			if err != nil {
+				return nil, err
+			}
+			kind := tokString
+			if c == '`' {
+				kind = tokIdent
+			}
+			toks = append(toks, token{kind: kind, text: value})
+			i = end
+		case (c == 'x' || c == 'X') && i+1 < len(stmt) && stmt[i+1] == '\'':
+			end := strings.IndexByte(stmt[i+2:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated hex literal at %d", i)
+			}
+			b, err := hex.DecodeString(stmt[i+2 : i+2+end])
+			if err != nil {
+				return nil, fmt.Errorf("hex literal: %w", err)
+			}
+			toks = append(toks, token{kind: tokHex, text: string(b)})
+			i += end + 3
+		case c == '0' && i+1 < len(stmt) && (stmt[i+1] == 'x' || stmt[i+1] == 'X'):
+			j := i + 2
+			for j < len(stmt) && isHexDigit(stmt[j]) {
+				j++
+			}
+			b, err := hex.DecodeString(stmt[i+2 : j])
+			if err != nil {
+				return nil, fmt.Errorf("hex literal: %w", err)
+			}
+			toks = append(toks, token{kind: tokHex, text: string(b)})
+			i = j
+		case isWordByte(c) || c == '.' || c == '%' || c == '-':
+			j := i
+			for j < len(stmt) && (isWordByte(stmt[j]) || stmt[j] == '.' || stmt[j] == '%' || stmt[j] == '-') {
+				j++
+			}
+			toks = append(toks, token{kind: tokWord, text: stmt[i:j]})
+			i = j
+		default:
+			toks = append(toks, token{kind: tokPunct, text: string(c)})
+			i++
+		}
+	}
+	return toks, nil
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// tokenStream is a cursor over tokens.
+type tokenStream struct {
+	toks []token
+	pos  int
+}
+
+func (s *tokenStream) done() bool { return s.pos >= len(s.toks) }
+
+func (s *tokenStream) peek() token {
+	if s.done() {
+		return token{kind: tokPunct}
+	}
+	return s.toks[s.pos]
+}
+
+func (s *tokenStream) next() token {
+	t := s.peek()
+	s.pos++
+	return t
+}
+
+// accept consumes the keywords in order if they are all next.
+func (s *tokenStream) accept(words ...string) bool {
+	if s.pos+len(words) > len(s.toks) {
+		return false
+	}
+	for i, w := range words {
+		if !s.toks[s.pos+i].is(w) {
+			return false
+		}
+	}
+	s.pos += len(words)
+	return true
+}
+
+// identity reads user[@host]; a missing host means '%'.
+func (s *tokenStream) identity() (Identity, error) {
+	user := s.next()
+	if user.kind == tokPunct {
+		return Identity{}, fmt.Errorf("expected account name, got %q", user.text)
+	}
+	id := Identity{User: user.text, Host: "%"}
+	if s.peek().is("@") {
+		s.next()
+		host := s.next()
+		if host.kind == tokPunct {
+			return Identity{}, fmt.Errorf("expected host for %s, got %q", user.text, host.text)
+		}
+		id.Host = host.text
+	}
+	return id, nil
+}
+
+// literal reads a string or hex literal.
+func (s *tokenStream) literal() (string, error) {
+	t := s.next()
+	if t.kind != tokString && t.kind != tokHex {
+		return "", fmt.Errorf("expected string literal, got %q", t.text)
+	}
+	return t.text, nil
+}
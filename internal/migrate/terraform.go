@@ -0,0 +1,233 @@
+package migrate
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Terraform renders the snapshot as configuration for the petoju/mysql provider: a
+// mysql_user (or mysql_role) per account and a mysql_grant per grant, each followed by an
+// import block so existing accounts are adopted rather than recreated. With HashesVariables
+// the hashes are referenced as sensitive variables and returned separately as tfvars. Grants
+// the provider cannot express are written as comments and returned as warnings.
+func (s *Snapshot) Terraform(hashes HashMode) (hcl, tfvars []byte, warnings []string, err error) {
+	users, err := s.Users()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var (
+		b     strings.Builder
+		vars  strings.Builder
+		names = make(tfNames)
+		decls []string
+	)
+	fmt.Fprintf(&b, "# Generated by mysql-user-migrate from %s at %s.\n", s.Source, s.CreatedAt.UTC().Format(time.RFC3339))
+	b.WriteString("# Import blocks need Terraform 1.5 or later.\n\n")
+	b.WriteString("terraform {\n  required_providers {\n    mysql = {\n      source = \"petoju/mysql\"\n    }\n  }\n}\n")
+
+	for _, u := range users {
+		fmt.Fprintf(&b, "\n# %s\n", u.RawIdentity)
+		if u.Role && u.Host == "%" {
+			name := names.next(u.User)
+			writeHCLBlock(&b, `resource "mysql_role" "`+name+`"`, []hclAttr{{"name", hclString(u.User)}})
+			writeHCLBlock(&b, "import", []hclAttr{{"to", "mysql_role." + name}, {"id", hclString(u.User)}})
+		} else {
+			name := names.next(u.User + "_" + u.Host)
+			attrs := []hclAttr{{"user", hclString(u.User)}, {"host", hclString(u.Host)}}
+			if u.Plugin != "" {
+				attrs = append(attrs, hclAttr{"auth_plugin", hclString(u.Plugin)})
+			}
+			if u.AuthString != "" && hashes != HashesOmit {
+				attr, value := "auth_string_hashed", u.AuthString
+				if !isPrintable(value) {
+					attr, value = "auth_string_hex", hex.EncodeToString([]byte(value))
+				}
+				if hashes == HashesVariables {
+					variable := name + "_auth"
+					decls = append(decls, variable)
+					fmt.Fprintf(&vars, "%s = %s\n", variable, hclString(value))
+					attrs = append(attrs, hclAttr{attr, "var." + variable})
+				} else {
+					attrs = append(attrs, hclAttr{attr, hclString(value)})
+				}
+			}
+			if tls := tlsOption(u.Attributes["ssl_type"]); tls != "" {
+				attrs = append(attrs, hclAttr{"tls_option", hclString(tls)})
+			}
+			writeHCLBlock(&b, `resource "mysql_user" "`+name+`"`, attrs)
+			writeHCLBlock(&b, "import", []hclAttr{{"to", "mysql_user." + name}, {"id", hclString(u.RawIdentity)}})
+		}
+
+		for _, raw := range u.Grants {
+			ok, err := writeTerraformGrant(&b, names, u, raw)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("%s: %w", u.RawIdentity, err)
+			}
+			if !ok {
+				warnings = append(warnings, fmt.Sprintf("%s: not representable in the provider: %s", u.RawIdentity, raw))
+			}
+		}
+	}
+
+	for _, variable := range decls {
+		b.WriteString("\n")
+		writeHCLBlock(&b, `variable "`+variable+`"`, []hclAttr{{"type", "string"}, {"sensitive", "true"}})
+	}
+	if vars.Len() > 0 {
+		tfvars = []byte(vars.String())
+	}
+	return []byte(b.String()), tfvars, warnings, nil
+}
+
+// writeTerraformGrant writes the resource for one grant. It reports false when the grant
+// was left as a comment: the provider has no proxy grants and no partial revokes. Role
+// grants and grants to roles get no import block because the provider's import ID only
+// addresses a user's privileges on a database and table; applying them is harmless, as
+// granting what an account already holds changes nothing.
+func writeTerraformGrant(b *strings.Builder, names tfNames, u UserRecord, raw string) (bool, error) {
+	g, err := parseStatement(raw)
+	if err != nil {
+		return false, err
+	}
+	grantee := []hclAttr{{"user", hclString(u.User)}, {"host", hclString(u.Host)}}
+	if u.Role && u.Host == "%" {
+		grantee = []hclAttr{{"role", hclString(u.User)}}
+	}
+
+	switch {
+	case g.Revoke || g.Kind == ProxyGrant:
+		fmt.Fprintf(b, "# Not representable in the provider: %s\n", raw)
+		return false, nil
+	case g.Kind == RoleGrant:
+		roles := make([]string, len(g.Roles))
+		for i, role := range g.Roles {
+			roles[i] = role.User
+			if role.Host != "%" {
+				roles[i] = role.String()
+			}
+		}
+		attrs := append(grantee, hclAttr{"roles", hclList(roles)})
+		if g.AdminOption {
+			attrs = append(attrs, hclAttr{"grant", "true"})
+		}
+		writeHCLBlock(b, `resource "mysql_grant" "`+names.next(u.User+"_roles")+`"`, attrs)
+		return true, nil
+	}
+
+	if len(g.Privileges) == 1 && g.Privileges[0] == "USAGE" && !g.GrantOption {
+		return true, nil // implied by the account itself
+	}
+	obj := g.ParseObject()
+	database, table := obj.Database, obj.Table
+	if obj.Routine != "" {
+		database, table = obj.Routine+" "+obj.Database+"."+obj.Table, "*"
+	}
+	attrs := append(grantee,
+		hclAttr{"database", hclString(database)},
+		hclAttr{"table", hclString(table)},
+		hclAttr{"privileges", hclList(g.Privileges)},
+	)
+	if g.GrantOption {
+		attrs = append(attrs, hclAttr{"grant", "true"})
+	}
+	name := names.next(u.User + "_" + database + "_" + table)
+	writeHCLBlock(b, `resource "mysql_grant" "`+name+`"`, attrs)
+	if len(grantee) == 2 {
+		id := strings.Join([]string{u.User, u.Host, database, table}, "@")
+		writeHCLBlock(b, "import", []hclAttr{{"to", "mysql_grant." + name}, {"id", hclString(id)}})
+	}
+	return true, nil
+}
+
+// tlsOption maps mysql.user ssl_type to the provider's tls_option.
+func tlsOption(sslType string) string {
+	switch sslType {
+	case "ANY":
+		return "SSL"
+	case "X509":
+		return "X509"
+	}
+	return ""
+}
+
+type hclAttr struct {
+	key   string
+	value string // HCL expression
+}
+
+// writeHCLBlock writes a block with its attributes aligned as terraform fmt would.
+func writeHCLBlock(b *strings.Builder, header string, attrs []hclAttr) {
+	width := 0
+	for _, a := range attrs {
+		width = max(width, len(a.key))
+	}
+	fmt.Fprintf(b, "%s {\n", header)
+	for _, a := range attrs {
+		fmt.Fprintf(b, "  %-*s = %s\n", width, a.key, a.value)
+	}
+	b.WriteString("}\n")
+}
+
+// hclString quotes s, escaping template sequences.
+func hclString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case (r == '$' || r == '%') && strings.HasPrefix(s[i+1:], "{"):
+			b.WriteRune(r)
+			b.WriteRune(r)
+		case !unicode.IsPrint(r):
+			fmt.Fprintf(&b, `\u%04X`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func hclList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = hclString(item)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// tfNames hands out unique resource names derived from account and object names.
+type tfNames map[string]int
+
+func (n tfNames) next(raw string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(raw) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	name := strings.Trim(b.String(), "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "a_" + name
+	}
+	n[name]++
+	if n[name] > 1 {
+		name += "_" + strconv.Itoa(n[name])
+	}
+	return name
+}
@@ -0,0 +1,63 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSnapshotTerraform(t *testing.T) {
+	snap := &Snapshot{Version: SnapshotVersion, Source: "src"}
+	for _, u := range []UserRecord{
+		{User: "reader", Host: "%", Role: true, Grants: []string{
+			"GRANT USAGE ON *.* TO `reader`@`%`",
+			"GRANT SELECT ON `shop`.* TO `reader`@`%`",
+		}},
+		{User: "app", Host: "10.0.%", Plugin: "caching_sha2_password", AuthString: "$A$005$\x01\x02", Attributes: map[string]string{"ssl_type": "ANY"}, Grants: []string{
+			"GRANT USAGE ON *.* TO `app`@`10.0.%`",
+			"GRANT EXECUTE ON PROCEDURE `shop`.`refund` TO `app`@`10.0.%` WITH GRANT OPTION",
+			"GRANT `reader`@`%` TO `app`@`10.0.%`",
+			"REVOKE DELETE ON `shop`.* FROM `app`@`10.0.%`",
+		}},
+	} {
+		snap.Accounts = append(snap.Accounts, snapshotAccount(u))
+	}
+
+	hcl, tfvars, warnings, err := snap.Terraform(HashesVariables)
+	if err != nil {
+		t.Fatalf("Terraform: %v", err)
+	}
+	for _, want := range []string{
+		"resource \"mysql_role\" \"reader\" {\n  name = \"reader\"\n}",
+		"import {\n  to = mysql_role.reader\n  id = \"reader\"\n}",
+		"  role       = \"reader\"\n  database   = \"shop\"\n  table      = \"*\"\n  privileges = [\"SELECT\"]",
+		"resource \"mysql_user\" \"app_10_0\" {",
+		"  auth_string_hex = var.app_10_0_auth\n  tls_option      = \"SSL\"",
+		"id = \"app@10.0.%\"",
+		"  database   = \"PROCEDURE shop.refund\"\n  table      = \"*\"\n  privileges = [\"EXECUTE\"]\n  grant      = true",
+		"id = \"app@10.0.%@PROCEDURE shop.refund@*\"",
+		"  roles = [\"reader\"]",
+		"variable \"app_10_0_auth\" {\n  type      = string\n  sensitive = true\n}",
+		"# Not representable in the provider: REVOKE DELETE ON `shop`.* FROM `app`@`10.0.%`",
+	} {
+		if !strings.Contains(string(hcl), want) {
+			t.Errorf("terraform output missing %q:\n%s", want, hcl)
+		}
+	}
+	if strings.Contains(string(hcl), "USAGE") {
+		t.Errorf("USAGE grants should be implied:\n%s", hcl)
+	}
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "app@10.0.%: not representable in the provider: REVOKE DELETE") {
+		t.Errorf("warnings = %q", warnings)
+	}
+	if string(tfvars) != "app_10_0_auth = \"244124303035240102\"\n" {
+		t.Errorf("tfvars = %q", tfvars)
+	}
+
+	hcl, tfvars, _, err = snap.Terraform(HashesOmit)
+	if err != nil {
+		t.Fatalf("Terraform: %v", err)
+	}
+	if tfvars != nil || strings.Contains(string(hcl), "auth_string") {
+		t.Errorf("omit mode should not write hashes:\n%s", hcl)
+	}
+}
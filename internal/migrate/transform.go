@@ -0,0 +1,172 @@
+package migrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/config"
+)
+
+// targetUser is a source account prepared for a single target.
+type targetUser struct {
+	UserRecord
+	Transforms []string // privilege rule changes applied to the account's grants
+	Err        error    // set when the account cannot be migrated to this target
+
+	// Missing-object decisions made before applying.
+	Skipped    []GrantResult     // grants removed from Grants
+	GrantNotes map[string]string // notes on grants that are still applied, by statement
+	Schemas    []string          // empty databases to create before the grants
+
+	Backup *accountBackup // definition before the run, when backups are taken
+}
+
+// prepareTarget applies the user map, the target's host rewrites and the privilege rules that
+// match the target to the source users.
+func (r *Runner) prepareTarget(users []UserRecord, userRules []userRule, target config.Target) ([]targetUser, error) {
+	hostRules, err := compileHostRewrites(target.HostRewrite)
+	if err != nil {
+		return nil, err
+	}
+	privRules := compilePrivilegeRules(r.PrivilegeRules, target)
+
+	// Without rules every account keeps its identity, so its statements are left as read.
+	mapped, problems := users, map[string]error(nil)
+	if len(userRules) > 0 || len(hostRules) > 0 {
+		mapped, problems = mapUsers(users, chainMappers(userMapper(userRules), hostMapper(hostRules)))
+	}
+	out := make([]targetUser, 0, len(mapped))
+	for _, user := range mapped {
+		tu := targetUser{UserRecord: user, Err: problems[user.RawIdentity]}
+		if tu.Err == nil {
+			grants, notes, err := transformGrants(user.Grants, privRules)
+			if err != nil {
+				tu.Err = fmt.Errorf("privilege rules: %w", err)
+			} else {
+				tu.Grants, tu.Transforms = grants, notes
+			}
+		}
+		out = append(out, tu)
+	}
+	return out, nil
+}
+
+// identityMapper maps a source account to the account it becomes on a target.
+type identityMapper func(Identity) Identity
+
+// mapUsers applies fn to each user's identity and to every account referenced by its grants
+// and default roles.
+// Users that cannot be mapped, or whose mapped identities collide (host names compare without
+// case, as in MySQL), are returned in problems keyed by their source identity (RawIdentity).
+func mapUsers(users []UserRecord, fn identityMapper) ([]UserRecord, map[string]error) {
+	ids := make([]Identity, len(users))
+	claims := make(map[Identity][]string, len(users))
+	for i, user := range users {
+		ids[i] = fn(Identity{User: user.User, Host: user.Host})
+		key := Identity{User: ids[i].User, Host: strings.ToLower(ids[i].Host)}
+		claims[key] = append(claims[key], user.RawIdentity)
+	}
+
+	out := make([]UserRecord, 0, len(users))
+	problems := make(map[string]error)
+	for i, user := range users {
+		id := ids[i]
+		if sources := claims[Identity{User: id.User, Host: strings.ToLower(id.Host)}]; len(sources) > 1 {
+			problems[user.RawIdentity] = fmt.Errorf("collision: %s map to the same account %s", strings.Join(sources, ", "), id)
+			out = append(out, user)
+			continue
+		}
+
+		grants, err := remapGrants(user.Grants, fn)
+		if err != nil {
+			problems[user.RawIdentity] = fmt.Errorf("remap grants: %w", err)
+			out = append(out, user)
+			continue
+		}
+		user.User, user.Host, user.Grants = id.User, id.Host, grants
+		user.DefaultRoles = remapDefaultRoles(user.DefaultRoles, fn)
+		out = append(out, user)
+	}
+	return out, problems
+}
+
+// remapDefaultRoles applies fn to default roles given as user@host. The result is a new slice,
+// as records are shared between targets.
+func remapDefaultRoles(roles []string, fn identityMapper) []string {
+	if len(roles) == 0 {
+		return roles
+	}
+	out := make([]string, len(roles))
+	for i, role := range roles {
+		out[i] = role
+		if at := strings.LastIndex(role, "@"); at >= 0 {
+			out[i] = fn(Identity{User: role[:at], Host: role[at+1:]}).String()
+		}
+	}
+	return out
+}
+
+// hostMapper rewrites account hosts with the target's rules.
+func hostMapper(rules []hostRule) identityMapper {
+	return func(id Identity) Identity {
+		id.Host = rewriteHost(rules, id.Host)
+		return id
+	}
+}
+
+// userRule is a compiled config.UserMapping.
+type userRule struct {
+	from    string
+	to      string
+	pattern *regexp.Regexp
+}
+
+func compileUserMap(mappings []config.UserMapping) ([]userRule, error) {
+	out := make([]userRule, 0, len(mappings))
+	for _, m := range mappings {
+		if m.From == "" || m.To == "" {
+			return nil, fmt.Errorf("user map %q -> %q: from and to are required", m.From, m.To)
+		}
+		rule := userRule{from: m.From, to: m.To}
+		if strings.ContainsAny(m.From, "%*") {
+			rule.pattern = globRegexp(m.From, false)
+		}
+		out = append(out, rule)
+	}
+	return out, nil
+}
+
+// renameUser applies the first matching rule to user.
+func renameUser(rules []userRule, user string) string {
+	for _, rule := range rules {
+		if rule.pattern == nil {
+			if rule.from == user {
+				return rule.to
+			}
+			continue
+		}
+		if groups := rule.pattern.FindStringSubmatch(user); groups != nil {
+			return fillWildcards(rule.to, groups[1:])
+		}
+	}
+	return user
+}
+
+// userMapper renames accounts with the user map.
+func userMapper(rules []userRule) identityMapper {
+	return func(id Identity) Identity {
+		id.User = renameUser(rules, id.User)
+		return id
+	}
+}
+
+// chainMappers applies mappers in order.
+func chainMappers(mappers ...identityMapper) identityMapper {
+	return func(id Identity) Identity {
+		for _, fn := range mappers {
+			id = fn(id)
+		}
+		return id
+	}
+}
@@ -10,20 +10,46 @@ import (
 
 // UserRecord holds source-side user information.
 type UserRecord struct {
-	User        string
-	Host        string
-	Plugin      string
-	AuthString  string
-	Grants      []string
-	RawIdentity string
+	User         string
+	Host         string
+	Plugin       string
+	AuthString   string
+	Grants       []string
+	RawIdentity  string
+	Role         bool              // granted to other accounts as a role
+	DefaultRoles []string          // user@host of the account's default roles
+	Attributes   map[string]string // lock, expiry, TLS and resource-limit settings from mysql.user
+	SecretRef    string            // desired-state password reference resolved through Runner.Secrets
 }
 
 // UserResult captures the outcome per user on a target.
 type UserResult struct {
-	User   string `json:"user"`
-	Host   string `json:"host"`
-	Status string `json:"status"`
-	Error  string `json:"error,omitempty"`
+	User       string        `json:"user"`
+	Host       string        `json:"host"`
+	Source     string        `json:"source,omitempty"` // source account, when renamed for the target
+	Status     string        `json:"status"`
+	Auth       string        `json:"auth,omitempty"` // how the password was set: copied, rotated, secret-file or secret-command
+	Error      string        `json:"error,omitempty"`
+	Transforms []string      `json:"transforms,omitempty"` // privilege rule changes applied
+	Grants     []GrantResult `json:"grants,omitempty"`
+	Resumed    bool          `json:"resumed,omitempty"` // completed by an earlier run, from its journal
+	Retries    int           `json:"retries,omitempty"` // statements and reads tried again after transient errors
+}
+
+// GrantResult is the outcome of a single grant: applied, scripted, planned, skipped or error.
+type GrantResult struct {
+	Grant       string `json:"grant"`
+	Status      string `json:"status"`
+	Note        string `json:"note,omitempty"` // missing-object decision
+	Error       string `json:"error,omitempty"`
+	ErrorNumber uint16 `json:"error_number,omitempty"` // MySQL error number
+}
+
+// Finding is a pre-flight compatibility problem found on a target before any change.
+type Finding struct {
+	Severity string `json:"severity"` // error or warning
+	Account  string `json:"account,omitempty"`
+	Message  string `json:"message"`
 }
 
 // TargetReport summarizes migration to a single target.
@@ -32,14 +58,47 @@ type TargetReport struct {
 	Applied    int          `json:"applied"`
 	Skipped    int          `json:"skipped"`
 	Failed     int          `json:"failed"`
+	Cancelled  int          `json:"cancelled,omitempty"`   // not started because the run was cancelled
+	RolledBack int          `json:"rolled_back,omitempty"` // restored to their state before the run
 	Users      []UserResult `json:"users"`
 	Error      string       `json:"error,omitempty"`
+	Precheck   []string     `json:"precheck,omitempty"` // privileges the connection is missing
+	Findings   []Finding    `json:"findings,omitempty"` // pre-flight compatibility checks
+	Script     string       `json:"script,omitempty"`   // SQL plan written in script mode
+	Retries    int          `json:"retries,omitempty"`  // connection attempts and target reads after transient errors
+	Undo       string       `json:"undo,omitempty"`     // undo script written before any change
+	Rollback   string       `json:"rollback,omitempty"` // outcome of running the undo statements
+	Timeout    string       `json:"timeout,omitempty"`  // set when the target ran out of time
 	DurationMS int64        `json:"duration_ms"`
 	DryRun     bool         `json:"dry_run"`
 	StartedAt  time.Time    `json:"started_at"`
 	FinishedAt time.Time    `json:"finished_at"`
 }
 
+// count adds a user outcome to the target totals.
+func (t *TargetReport) count(status string) {
+	switch status {
+	case "applied", "planned", "scripted":
+		t.Applied++
+	case "skipped":
+		t.Skipped++
+	case "cancelled":
+		t.Cancelled++
+	case "rolled-back":
+		t.RolledBack++
+	default:
+		t.Failed++
+	}
+}
+
+// recount recomputes the target totals from the user outcomes.
+func (t *TargetReport) recount() {
+	t.Applied, t.Skipped, t.Failed, t.Cancelled, t.RolledBack = 0, 0, 0, 0, 0
+	for _, u := range t.Users {
+		t.count(u.Status)
+	}
+}
+
 // Report aggregates all target reports.
 type Report struct {
 	Source      string         `json:"source"`
@@ -47,7 +106,9 @@ type Report struct {
 	StartedAt   time.Time      `json:"started_at"`
 	FinishedAt  time.Time      `json:"finished_at"`
 	Targets     []TargetReport `json:"targets"`
-	TotalFailed int            `json:"total_failed"`
+	TotalFailed int            `json:"total_failed"`        // failed and rolled-back accounts
+	Cancelled   bool           `json:"cancelled,omitempty"` // interrupted with accounts left; those are "cancelled"
+	TimedOut    bool           `json:"timed_out,omitempty"` // the run deadline passed; unfinished accounts are "timed-out"
 	TotalUsers  int            `json:"total_users"`
 }
 
@@ -65,20 +126,73 @@ func (r *Report) WriteJSON(path string) error {
 
 // Print renders a concise text summary.
 func (r *Report) Print(w io.Writer) {
-	fmt.Fprintf(w, "Migration report (dry-run=%v)\n", r.DryRun)
+	switch {
+	case r.Cancelled:
+		fmt.Fprintf(w, "Migration report (dry-run=%v, cancelled)\n", r.DryRun)
+	case r.TimedOut:
+		fmt.Fprintf(w, "Migration report (dry-run=%v, timed out)\n", r.DryRun)
+	default:
+		fmt.Fprintf(w, "Migration report (dry-run=%v)\n", r.DryRun)
+	}
 	fmt.Fprintf(w, "Source: %s\n", r.Source)
 	fmt.Fprintf(w, "Targets: %d | Duration: %s\n", len(r.Targets), r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond))
 	for _, t := range r.Targets {
-		fmt.Fprintf(w, "- %s | applied=%d skipped=%d failed=%d | duration=%s\n", t.Target, t.Applied, t.Skipped, t.Failed, time.Duration(t.DurationMS)*time.Millisecond)
+		counts := fmt.Sprintf("applied=%d skipped=%d failed=%d", t.Applied, t.Skipped, t.Failed)
+		if t.RolledBack > 0 {
+			counts += fmt.Sprintf(" rolled-back=%d", t.RolledBack)
+		}
+		if t.Cancelled > 0 {
+			counts += fmt.Sprintf(" cancelled=%d", t.Cancelled)
+		}
+		fmt.Fprintf(w, "- %s | %s | duration=%s\n", t.Target, counts, time.Duration(t.DurationMS)*time.Millisecond)
+		if t.Script != "" {
+			fmt.Fprintf(w, "  script: %s\n", t.Script)
+		}
+		if t.Undo != "" {
+			fmt.Fprintf(w, "  undo: %s\n", t.Undo)
+		}
+		if t.Timeout != "" {
+			fmt.Fprintf(w, "  timeout: %s\n", t.Timeout)
+		}
+		if t.Rollback != "" {
+			fmt.Fprintf(w, "  rollback: %s\n", t.Rollback)
+		}
+		for _, p := range t.Precheck {
+			fmt.Fprintf(w, "  precheck: %s\n", p)
+		}
+		for _, f := range t.Findings {
+			if f.Account != "" {
+				fmt.Fprintf(w, "  %s: %s: %s\n", f.Severity, f.Account, f.Message)
+			} else {
+				fmt.Fprintf(w, "  %s: %s\n", f.Severity, f.Message)
+			}
+		}
 		if t.Error != "" {
 			fmt.Fprintf(w, "  error: %s\n", t.Error)
 			continue
 		}
 		for _, u := range t.Users {
-			if u.Error != "" {
-				fmt.Fprintf(w, "  %s@%s -> %s (%s)\n", u.User, u.Host, u.Status, u.Error)
+			name := u.User + "@" + u.Host
+			if u.Source != "" {
+				name += " (from " + u.Source + ")"
+			}
+			if u.Resumed {
+				fmt.Fprintf(w, "  %s -> %s (earlier run)\n", name, u.Status)
+			} else if u.Error != "" {
+				fmt.Fprintf(w, "  %s -> %s (%s)\n", name, u.Status, u.Error)
 			} else {
-				fmt.Fprintf(w, "  %s@%s -> %s\n", u.User, u.Host, u.Status)
+				fmt.Fprintf(w, "  %s -> %s\n", name, u.Status)
+			}
+			for _, note := range u.Transforms {
+				fmt.Fprintf(w, "    transform: %s\n", note)
+			}
+			for _, g := range u.Grants {
+				switch {
+				case g.Error != "":
+					fmt.Fprintf(w, "    grant %s: %s (%s)\n", g.Status, g.Grant, g.Error)
+				case g.Note != "":
+					fmt.Fprintf(w, "    grant %s: %s (%s)\n", g.Status, g.Grant, g.Note)
+				}
 			}
 		}
 	}
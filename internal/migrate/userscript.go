@@ -0,0 +1,431 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/raojinlin/mysql-user-migrate/internal/crypt"
+)
+
+// ScriptSource reads accounts from SQL scripts of CREATE USER, ALTER USER and GRANT
+// statements, such as mysqlpump --users output or the @.users.sql file of a MySQL Shell
+// dump. Later scripts extend and override earlier ones. Key opens encrypted scripts.
+type ScriptSource struct {
+	Paths   []string
+	Key     *crypt.Key
+	version string
+}
+
+// Load parses the scripts and returns the matching accounts.
+func (s *ScriptSource) Load(_ context.Context, match func(user, host string) bool) ([]UserRecord, error) {
+	parser := newUserScript()
+	for _, path := range s.Paths {
+		data, err := crypt.ReadFile(path, s.Key)
+		if err != nil {
+			return nil, fmt.Errorf("read script %s: %w", path, err)
+		}
+		if err := parser.parse(string(data)); err != nil {
+			return nil, fmt.Errorf("script %s: %w", path, err)
+		}
+		if v := scriptServerVersion(string(data)); v != "" {
+			s.version = v
+		}
+	}
+	return filterUsers(parser.users(), match), nil
+}
+
+// Describe names the scripts.
+func (s *ScriptSource) Describe() string {
+	if len(s.Paths) == 1 {
+		return "sql script " + s.Paths[0]
+	}
+	return "sql scripts " + strings.Join(s.Paths, ", ")
+}
+
+// ServerVersion returns the server version named in the dump header, if any.
+func (s *ScriptSource) ServerVersion() string {
+	return s.version
+}
+
+// MySQL Shell headers name the server ("for MySQL 8.0.33"); mysqlpump only names its own
+// version, which matches the server it shipped with.
+var (
+	shellVersionRe = regexp.MustCompile(`(?m)^--.*\bfor MySQL ([0-9]+\.[0-9]+\.[0-9]+)`)
+	pumpVersionRe  = regexp.MustCompile(`(?m)^--.*\bversion: ([0-9]+\.[0-9]+\.[0-9]+)`)
+)
+
+func scriptServerVersion(script string) string {
+	for _, re := range []*regexp.Regexp{shellVersionRe, pumpVersionRe} {
+		if m := re.FindStringSubmatch(script); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// userScript accumulates accounts from parsed statements.
+type userScript struct {
+	accounts map[Identity]*UserRecord
+	order    []Identity
+	roles    map[Identity]bool
+}
+
+func newUserScript() *userScript {
+	return &userScript{accounts: make(map[Identity]*UserRecord), roles: make(map[Identity]bool)}
+}
+
+// account returns the record for id, creating it on first use.
+func (p *userScript) account(id Identity) *UserRecord {
+	if rec, ok := p.accounts[id]; ok {
+		return rec
+	}
+	rec := &UserRecord{User: id.User, Host: id.Host, RawIdentity: id.User + "@" + id.Host}
+	p.accounts[id] = rec
+	p.order = append(p.order, id)
+	return rec
+}
+
+// users returns the accounts in the order they first appeared.
+func (p *userScript) users() []UserRecord {
+	users := make([]UserRecord, 0, len(p.order))
+	for _, id := range p.order {
+		rec := *p.accounts[id]
+		rec.Role = rec.Role || p.roles[id]
+		users = append(users, rec)
+	}
+	return users
+}
+
+// parse applies every statement in script. Statements other than account management
+// (SET, USE, FLUSH PRIVILEGES, ...) are ignored.
+func (p *userScript) parse(script string) error {
+	for _, stmt := range splitStatements(script) {
+		if err := p.statement(stmt); err != nil {
+			return fmt.Errorf("%w: %s", err, abbreviate(stmt))
+		}
+	}
+	return nil
+}
+
+func (p *userScript) statement(stmt string) error {
+	if hasPrefixFold(stmt, "GRANT ") {
+		return p.grant(stmt)
+	}
+	if isRevoke(stmt) {
+		return p.revoke(stmt)
+	}
+	toks, err := tokenize(stmt)
+	if err != nil {
+		return err
+	}
+	s := &tokenStream{toks: toks}
+	switch {
+	case s.accept("CREATE", "USER"):
+		s.accept("IF", "NOT", "EXISTS")
+		return p.createUser(s)
+	case s.accept("CREATE", "ROLE"):
+		s.accept("IF", "NOT", "EXISTS")
+		return p.createRole(s)
+	case s.accept("ALTER", "USER"):
+		s.accept("IF", "EXISTS")
+		return p.alterUser(s)
+	case s.accept("SET", "DEFAULT", "ROLE"):
+		return p.setDefaultRole(s)
+	}
+	return nil
+}
+
+func (p *userScript) createUser(s *tokenStream) error {
+	var accounts []*UserRecord
+	for {
+		id, err := s.identity()
+		if err != nil {
+			return err
+		}
+		rec := p.account(id)
+		if err := readAuth(s, rec); err != nil {
+			return err
+		}
+		accounts = append(accounts, rec)
+		if !s.accept(",") {
+			break
+		}
+	}
+	return readAccountOptions(s, accounts)
+}
+
+// createRole records roles; MySQL creates them locked and without a password.
+func (p *userScript) createRole(s *tokenStream) error {
+	for {
+		id, err := s.identity()
+		if err != nil {
+			return err
+		}
+		rec := p.account(id)
+		rec.Role = true
+		setAttribute(rec, "account_locked", "Y")
+		if !s.accept(",") {
+			return nil
+		}
+	}
+}
+
+func (p *userScript) alterUser(s *tokenStream) error {
+	id, err := s.identity()
+	if err != nil {
+		return err
+	}
+	rec := p.account(id)
+	if s.accept("DEFAULT", "ROLE") {
+		return readDefaultRoles(s, []*UserRecord{rec})
+	}
+	if err := readAuth(s, rec); err != nil {
+		return err
+	}
+	return readAccountOptions(s, []*UserRecord{rec})
+}
+
+// setDefaultRole handles SET DEFAULT ROLE <roles> TO <accounts>.
+func (p *userScript) setDefaultRole(s *tokenStream) error {
+	var roles []string
+	switch {
+	case s.accept("NONE"):
+	case s.accept("ALL"):
+		return fmt.Errorf("SET DEFAULT ROLE ALL cannot be resolved from a script")
+	default:
+		for {
+			id, err := s.identity()
+			if err != nil {
+				return err
+			}
+			roles = append(roles, id.User+"@"+id.Host)
+			if !s.accept(",") {
+				break
+			}
+		}
+	}
+	if !s.accept("TO") {
+		return fmt.Errorf("missing TO clause")
+	}
+	for {
+		id, err := s.identity()
+		if err != nil {
+			return err
+		}
+		p.account(id).DefaultRoles = roles
+		if !s.accept(",") {
+			return nil
+		}
+	}
+}
+
+// grant attaches the statement to its grantee. Pre-5.7 scripts may create accounts with
+// GRANT ... IDENTIFIED BY PASSWORD, so the hash is taken from the grant when present.
+func (p *userScript) grant(stmt string) error {
+	g, err := ParseGrant(stmt)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(g.Extra, ",") {
+		return fmt.Errorf("grants to several accounts in one statement are not supported")
+	}
+	rec := p.account(g.Grantee)
+	rec.Grants = append(rec.Grants, strings.TrimSuffix(strings.TrimSpace(stmt), ";"))
+	for _, role := range g.Roles {
+		p.roles[role] = true
+	}
+	if idx := indexKeyword(g.Extra, "IDENTIFIED"); idx >= 0 && rec.AuthString == "" {
+		toks, err := tokenize(g.Extra[idx:])
+		if err != nil {
+			return err
+		}
+		if err := readAuth(&tokenStream{toks: toks}, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revoke attaches a partial revoke to its account, after the grants it restricts, as SHOW
+// GRANTS lists it on a live source.
+func (p *userScript) revoke(stmt string) error {
+	g, err := ParseRevoke(stmt)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(g.Extra, ",") {
+		return fmt.Errorf("revokes from several accounts in one statement are not supported")
+	}
+	rec := p.account(g.Grantee)
+	rec.Grants = append(rec.Grants, strings.TrimSuffix(strings.TrimSpace(stmt), ";"))
+	return nil
+}
+
+// readAuth reads an optional IDENTIFIED clause into rec. Dumps carry hashes; clear-text
+// passwords are rejected rather than migrated.
+func readAuth(s *tokenStream, rec *UserRecord) error {
+	if !s.accept("IDENTIFIED") {
+		return nil
+	}
+	switch {
+	case s.accept("WITH"):
+		plugin := s.next()
+		if plugin.kind == tokPunct {
+			return fmt.Errorf("%s: expected plugin name", rec.RawIdentity)
+		}
+		rec.Plugin = plugin.text
+		rec.AuthString = ""
+		if s.accept("AS") {
+			auth, err := s.literal()
+			if err != nil {
+				return fmt.Errorf("%s: %w", rec.RawIdentity, err)
+			}
+			rec.AuthString = auth
+		} else if s.accept("BY") {
+			return fmt.Errorf("%s: clear-text password in script; only hashed credentials can be migrated", rec.RawIdentity)
+		}
+	case s.accept("BY", "PASSWORD"):
+		auth, err := s.literal()
+		if err != nil {
+			return fmt.Errorf("%s: %w", rec.RawIdentity, err)
+		}
+		rec.Plugin = "mysql_native_password"
+		rec.AuthString = auth
+	case s.accept("BY"):
+		return fmt.Errorf("%s: clear-text password in script; only hashed credentials can be migrated", rec.RawIdentity)
+	default:
+		return fmt.Errorf("%s: unsupported IDENTIFIED clause", rec.RawIdentity)
+	}
+	return nil
+}
+
+// resourceLimits maps WITH clause options to mysql.user columns.
+var resourceLimits = map[string]string{
+	"MAX_QUERIES_PER_HOUR":     "max_questions",
+	"MAX_UPDATES_PER_HOUR":     "max_updates",
+	"MAX_CONNECTIONS_PER_HOUR": "max_connections",
+	"MAX_USER_CONNECTIONS":     "max_user_connections",
+}
+
+// readAccountOptions reads the clauses after the account list into attributes named after
+// the mysql.user columns, matching what a live source reports. Unknown clauses are skipped.
+func readAccountOptions(s *tokenStream, accounts []*UserRecord) error {
+	set := func(key, value string) {
+		for _, rec := range accounts {
+			setAttribute(rec, key, value)
+		}
+	}
+	for !s.done() {
+		switch {
+		case s.accept("DEFAULT", "ROLE"):
+			if err := readDefaultRoles(s, accounts); err != nil {
+				return err
+			}
+		case s.accept("REQUIRE"):
+			readRequire(s, set)
+		case s.accept("WITH"):
+			for {
+				column, ok := resourceLimits[strings.ToUpper(s.peek().text)]
+				if !ok {
+					break
+				}
+				s.next()
+				set(column, s.next().text)
+			}
+		case s.accept("PASSWORD", "EXPIRE"):
+			switch {
+			case s.accept("DEFAULT"):
+			case s.accept("NEVER"):
+				set("password_lifetime", "0")
+			case s.accept("INTERVAL"):
+				set("password_lifetime", s.next().text)
+				s.accept("DAY")
+			default:
+				set("password_expired", "Y")
+			}
+		case s.accept("PASSWORD", "HISTORY"):
+			if !s.accept("DEFAULT") {
+				set("password_reuse_history", s.next().text)
+			}
+		case s.accept("PASSWORD", "REUSE", "INTERVAL"):
+			if !s.accept("DEFAULT") {
+				set("password_reuse_time", s.next().text)
+				s.accept("DAY")
+			}
+		case s.accept("PASSWORD", "REQUIRE", "CURRENT"):
+			switch {
+			case s.accept("DEFAULT"):
+			case s.accept("OPTIONAL"):
+				set("password_require_current", "N")
+			default:
+				set("password_require_current", "Y")
+			}
+		case s.accept("ACCOUNT", "LOCK"):
+			set("account_locked", "Y")
+		case s.accept("ACCOUNT", "UNLOCK"):
+			set("account_locked", "N")
+		default:
+			s.next()
+		}
+	}
+	return nil
+}
+
+func readRequire(s *tokenStream, set func(key, value string)) {
+	switch {
+	case s.accept("NONE"):
+	case s.accept("SSL"):
+		set("ssl_type", "ANY")
+	case s.accept("X509"):
+		set("ssl_type", "X509")
+	default:
+		set("ssl_type", "SPECIFIED")
+		for s.accept("ISSUER") || s.accept("SUBJECT") || s.accept("CIPHER") || s.accept("AND") {
+			if s.peek().kind == tokString {
+				s.next()
+			}
+		}
+	}
+}
+
+func readDefaultRoles(s *tokenStream, accounts []*UserRecord) error {
+	var roles []string
+	if !s.accept("NONE") {
+		for {
+			id, err := s.identity()
+			if err != nil {
+				return err
+			}
+			roles = append(roles, id.User+"@"+id.Host)
+			if !s.accept(",") {
+				break
+			}
+		}
+	}
+	for _, rec := range accounts {
+		rec.DefaultRoles = roles
+	}
+	return nil
+}
+
+func setAttribute(rec *UserRecord, key, value string) {
+	if rec.Attributes == nil {
+		rec.Attributes = make(map[string]string)
+	}
+	rec.Attributes[key] = value
+}
+
+// abbreviate shortens a statement for error messages without printing credentials.
+func abbreviate(stmt string) string {
+	for _, kw := range []string{"IDENTIFIED", "VALUES"} {
+		if idx := indexKeyword(stmt, kw); idx >= 0 {
+			stmt = stmt[:idx] + kw + " ..."
+		}
+	}
+	if len(stmt) > 80 {
+		stmt = stmt[:77] + "..."
+	}
+	return stmt
+}
@@ -0,0 +1,113 @@
+package migrate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const pumpScript = `-- Dump created by MySQL pump utility, version: 8.0.36, Linux (x86_64)
+-- Dump start time: Mon Jan 01 00:00:00 2026
+SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0;
+CREATE USER 'app'@'%' IDENTIFIED WITH 'mysql_native_password' AS '*6BB4837EB74329105EE4568DDA7DC67ED2CA2AD9' REQUIRE NONE WITH MAX_USER_CONNECTIONS 20 PASSWORD EXPIRE DEFAULT ACCOUNT UNLOCK PASSWORD HISTORY DEFAULT PASSWORD REUSE INTERVAL DEFAULT PASSWORD REQUIRE CURRENT DEFAULT;
+GRANT SELECT, INSERT ON ` + "`shop`" + `.* TO 'app'@'%';
+CREATE USER 'reader'@'%' IDENTIFIED WITH 'mysql_native_password' REQUIRE NONE PASSWORD EXPIRE DEFAULT ACCOUNT LOCK;
+GRANT SELECT ON *.* TO 'reader'@'%';
+REVOKE SELECT ON ` + "`mysql`" + `.* FROM 'reader'@'%';
+SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS;
+`
+
+const shellScript = `-- MySQLShell dump 2.0.1  Distrib Ver 8.0.36 for Linux on x86_64 - for MySQL 8.0.36 (MySQL Community Server (GPL)), for Linux (x86_64)
+--
+-- Dumping user accounts
+--
+
+-- begin user 'svc'@'10.0.%'
+/*!80001 CREATE USER IF NOT EXISTS 'svc'@'10.0.%' IDENTIFIED WITH 'caching_sha2_password' AS 0x244124303035240102 REQUIRE SSL PASSWORD EXPIRE DEFAULT ACCOUNT UNLOCK */;
+-- end user 'svc'@'10.0.%'
+
+-- begin grants 'svc'@'10.0.%'
+GRANT USAGE ON *.* TO ` + "`svc`@`10.0.%`" + `;
+GRANT ` + "`reader`@`%`" + ` TO ` + "`svc`@`10.0.%`" + `;
+-- end grants 'svc'@'10.0.%'
+
+-- begin default roles
+ALTER USER 'svc'@'10.0.%' DEFAULT ROLE 'reader'@'%';
+-- end default roles
+`
+
+func TestSplitStatements(t *testing.T) {
+	script := "-- comment\nSELECT 'a;b'; /* block; */ SELECT 1;\n# hash\n/*!80001 SET x = 1 */;\nDELIMITER //\nCREATE PROCEDURE p() BEGIN SELECT 1; END//\nDELIMITER ;\nSELECT 2"
+	got := splitStatements(script)
+	want := []string{"SELECT 'a;b'", "SELECT 1", "SET x = 1", "CREATE PROCEDURE p() BEGIN SELECT 1; END", "SELECT 2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("splitStatements = %q, want %q", got, want)
+	}
+}
+
+func TestScriptSource(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for name, content := range map[string]string{"pump.sql": pumpScript, "@.users.sql": shellScript} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	src := &ScriptSource{Paths: paths}
+	users, err := src.Load(context.Background(), func(user, host string) bool { return true })
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if src.ServerVersion() != "8.0.36" {
+		t.Fatalf("ServerVersion = %q", src.ServerVersion())
+	}
+	byName := make(map[string]UserRecord)
+	for _, u := range users {
+		byName[u.RawIdentity] = u
+	}
+	if len(byName) != 3 {
+		t.Fatalf("loaded %d accounts: %+v", len(users), users)
+	}
+
+	app := byName["app@%"]
+	if app.Plugin != "mysql_native_password" || app.AuthString != "*6BB4837EB74329105EE4568DDA7DC67ED2CA2AD9" {
+		t.Fatalf("app auth = %q %q", app.Plugin, app.AuthString)
+	}
+	if app.Attributes["max_user_connections"] != "20" || app.Attributes["account_locked"] != "N" {
+		t.Fatalf("app attributes = %v", app.Attributes)
+	}
+	if len(app.Grants) != 1 || !strings.Contains(app.Grants[0], "`shop`.*") {
+		t.Fatalf("app grants = %q", app.Grants)
+	}
+
+	reader := byName["reader@%"]
+	if !reader.Role || reader.AuthString != "" || reader.Attributes["account_locked"] != "Y" {
+		t.Fatalf("reader = %+v", reader)
+	}
+	// The partial revoke stays with the account, after the grant it restricts.
+	if want := []string{"GRANT SELECT ON *.* TO 'reader'@'%'", "REVOKE SELECT ON `mysql`.* FROM 'reader'@'%'"}; !reflect.DeepEqual(reader.Grants, want) {
+		t.Fatalf("reader grants = %q, want %q", reader.Grants, want)
+	}
+
+	svc := byName["svc@10.0.%"]
+	if svc.AuthString != "$A$005$\x01\x02" || svc.Attributes["ssl_type"] != "ANY" {
+		t.Fatalf("svc = %+v", svc)
+	}
+	if !reflect.DeepEqual(svc.DefaultRoles, []string{"reader@%"}) || len(svc.Grants) != 2 {
+		t.Fatalf("svc roles = %q, grants = %q", svc.DefaultRoles, svc.Grants)
+	}
+}
+
+func TestScriptSourceRejectsClearText(t *testing.T) {
+	p := newUserScript()
+	err := p.parse("CREATE USER 'x'@'%' IDENTIFIED BY 'secret';")
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("parse error = %v, want clear-text rejection without the password", err)
+	}
+}